@@ -0,0 +1,178 @@
+package htmlfmt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/net/html"
+)
+
+// TagCheck configures the checking of the tag structure of the source,
+// see WithTagCheck.
+type TagCheck int
+
+const (
+	// TagCheckOff does not check the tags.
+	TagCheckOff TagCheck = iota
+
+	// TagCheckWarn reports the problems found to the warning handler, see
+	// WithWarningHandler.
+	TagCheckWarn
+
+	// TagCheckStrict makes Format return the first problem found as an
+	// error, without writing anything.
+	TagCheckStrict
+)
+
+// WithTagCheck configures the formatter to check that the end tags in the
+// source match the start tags, e.g. to find a stray </div>, which would
+// otherwise quietly give the wrong indentation.
+// The problems are reported as a *FormatError wrapping a *TagError.
+//
+// Elements opened or closed inside template blocks, see WithGoTemplates,
+// are only checked against each other, as e.g. the branches of an
+// {{ if }} may open different elements closed after the {{ end }}.
+//
+// The default is TagCheckOff.
+func WithTagCheck(check TagCheck) Option {
+	return func(f *Formatter) { f.tagCheck = check }
+}
+
+// The problems with the tag structure of the source, see TagError.
+var (
+	// ErrUnclosedElement is reported for an element without an end tag,
+	// at its start tag.
+	ErrUnclosedElement = errors.New("unclosed element")
+
+	// ErrUnexpectedEndTag is reported for an end tag without a start tag.
+	ErrUnexpectedEndTag = errors.New("unexpected end tag")
+
+	// ErrMisnestedElement is reported for an inline element ended after
+	// the inline element it is in, e.g. the <i> in <b><i>text</b></i>,
+	// at the end tag of the outer element.
+	ErrMisnestedElement = errors.New("misnested element")
+)
+
+// TagError is a problem with the tag structure of the source, see
+// WithTagCheck.
+type TagError struct {
+	// One of ErrUnclosedElement, ErrUnexpectedEndTag or
+	// ErrMisnestedElement.
+	Err error
+
+	// The name of the element, e.g. "div".
+	Name string
+
+	// For ErrMisnestedElement, the element ended first, e.g. "b" for
+	// <b><i>text</b></i>.
+	Parent string
+}
+
+func (e *TagError) Error() string {
+	switch e.Err {
+	case ErrUnexpectedEndTag:
+		return fmt.Sprintf("%s </%s>", e.Err, e.Name)
+	case ErrMisnestedElement:
+		return fmt.Sprintf("%s <%s> ended by </%s>", e.Err, e.Name, e.Parent)
+	default:
+		return fmt.Sprintf("%s <%s>", e.Err, e.Name)
+	}
+}
+
+// Unwrap returns the underlying error, e.g. ErrUnclosedElement.
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
+
+// openElement is an element without an end tag yet, see parser.checkTag.
+type openElement struct {
+	name string
+	pos  position
+
+	// Opened inside a template block, see WithTagCheck.
+	template bool
+
+	// Reported as misnested and reopened, as a browser would.
+	misnested bool
+}
+
+// tagError is a TagError at a position in the source.
+type tagError struct {
+	pos position
+	err *TagError
+}
+
+// checkTag checks the current start or end tag against the open
+// elements.
+func (prs *parser) checkTag() {
+	name := string(prs.tagName)
+
+	switch prs.currType {
+	case html.StartTagToken:
+		if !prs.elements.isVoid(name) {
+			prs.open = append(prs.open, openElement{name: name, pos: prs.pos, template: prs.templateDepth > 0})
+		}
+	case html.EndTagToken:
+		i := len(prs.open) - 1
+		for i >= 0 && prs.open[i].name != name {
+			i--
+		}
+		if i < 0 {
+			if prs.templateDepth == 0 {
+				prs.tagErrors = append(prs.tagErrors, tagError{prs.pos, &TagError{Err: ErrUnexpectedEndTag, Name: name}})
+			}
+			return
+		}
+
+		report := prs.templateDepth == 0 && !prs.open[i].template
+		var reopen []openElement
+		for _, e := range prs.open[i+1:] {
+			misnested := prs.elements.isInline(name) && prs.elements.isInline(e.name)
+			if report && !e.template && !e.misnested && !hasOptionalEndTag(e.name) {
+				if misnested {
+					prs.tagErrors = append(prs.tagErrors, tagError{prs.pos, &TagError{Err: ErrMisnestedElement, Name: e.name, Parent: name}})
+				} else {
+					prs.tagErrors = append(prs.tagErrors, tagError{e.pos, &TagError{Err: ErrUnclosedElement, Name: e.name}})
+				}
+			}
+			if misnested {
+				e.misnested = true
+				reopen = append(reopen, e)
+			}
+		}
+		prs.open = append(prs.open[:i], reopen...)
+	}
+}
+
+// checkUnclosed reports the elements left open at the end of the source.
+func (prs *parser) checkUnclosed() {
+	for _, e := range prs.open {
+		if !e.template && !e.misnested && !hasOptionalEndTag(e.name) {
+			prs.tagErrors = append(prs.tagErrors, tagError{e.pos, &TagError{Err: ErrUnclosedElement, Name: e.name}})
+		}
+	}
+	prs.open = nil
+}
+
+// reportTagErrors reports the tag errors found in src by p, see
+// WithTagCheck. In strict mode the first is returned.
+func (f *Formatter) reportTagErrors(src []byte, p *parser) error {
+	if p.off {
+		return nil
+	}
+
+	errs := p.tagErrors
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].pos.offset < errs[j].pos.offset })
+
+	for _, e := range errs {
+		err := newFormatError(src, e.pos, e.err)
+		if f.tagCheck == TagCheckStrict {
+			return err
+		}
+		if f.warningHandler != nil {
+			f.warningHandler(err)
+		}
+	}
+	return nil
+}
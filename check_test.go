@@ -0,0 +1,76 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestTagCheck(t *testing.T) {
+	c := qt.New(t)
+
+	warnings := func(src string, opts ...Option) []string {
+		var warnings []string
+		opts = append(opts, WithTagCheck(TagCheckWarn), WithWarningHandler(func(err error) {
+			warnings = append(warnings, err.Error())
+		}))
+		var b bytes.Buffer
+		c.Assert(New(opts...).Format(&b, strings.NewReader(src)), qt.IsNil)
+		c.Assert(b.Len() > 0, qt.IsTrue)
+		return warnings
+	}
+
+	c.Assert(warnings("<div><p>a</p><br><img></div>"), qt.IsNil)
+	c.Assert(warnings("<div>\n<section>a</div>\n</div>"), qt.DeepEquals, []string{
+		"2:1: unclosed element <section>",
+		"3:1: unexpected end tag </div>",
+	})
+	c.Assert(warnings("<div>\n  <section>"), qt.DeepEquals, []string{
+		"1:1: unclosed element <div>",
+		"2:3: unclosed element <section>",
+	})
+	c.Assert(warnings("<p><b><i>a</b></i></p>"), qt.DeepEquals, []string{
+		"1:11: misnested element <i> ended by </b>",
+	})
+	c.Assert(warnings("<p><b><i>a</b></p>"), qt.DeepEquals, []string{
+		"1:11: misnested element <i> ended by </b>",
+	})
+
+	// Optional end tags.
+	c.Assert(warnings("<ul><li>a<li>b</ul>\n<p>c"), qt.IsNil)
+
+	// Ignored and preformatted content is not checked.
+	c.Assert(warnings("<!-- htmlfmt-ignore -->\n<div></span></div><pre><b></pre>"), qt.IsNil)
+	c.Assert(warnings("<div><!-- htmlfmt:off --></span>"), qt.IsNil)
+
+	// Elements in template blocks are only checked against each other.
+	c.Assert(warnings("{{ if .X }}<a href=\"x\">{{ else }}<span>{{ end }}a{{ if .X }}</a>{{ else }}</span>{{ end }}", WithGoTemplates()), qt.IsNil)
+	c.Assert(warnings("<ul>{{ range .X }}<li>{{ end }}</ul>", WithGoTemplates()), qt.IsNil)
+	c.Assert(warnings("<div>{{ if .X }}<p>{{ end }}\n</span>", WithGoTemplates()), qt.DeepEquals, []string{
+		"1:1: unclosed element <div>",
+		"2:1: unexpected end tag </span>",
+	})
+
+	// Structured.
+	var fe *FormatError
+	var te *TagError
+	err := New(WithTagCheck(TagCheckStrict)).Format(&bytes.Buffer{}, strings.NewReader("<div>\n  </span></div>"))
+	c.Assert(errors.As(err, &fe), qt.IsTrue)
+	c.Assert(errors.As(err, &te), qt.IsTrue)
+	c.Assert(errors.Is(err, ErrUnexpectedEndTag), qt.IsTrue)
+	c.Assert(te.Name, qt.Equals, "span")
+	c.Assert(fe.Excerpt, qt.Equals, "  </span></div>")
+
+	// Strict mode writes nothing.
+	var b bytes.Buffer
+	err = New(WithTagCheck(TagCheckStrict)).Format(&b, strings.NewReader("<div><span>a</div>"))
+	c.Assert(err, qt.ErrorMatches, "1:6: unclosed element <span>")
+	c.Assert(b.Len(), qt.Equals, 0)
+	err = New(WithTagCheck(TagCheckStrict), WithGoTemplates()).Format(&b, strings.NewReader("{{ .X }}<div><span>a</div>"))
+	c.Assert(err, qt.ErrorMatches, "1:14: unclosed element <span>")
+	c.Assert(b.Len(), qt.Equals, 0)
+	c.Assert(New(WithTagCheck(TagCheckStrict)).Format(&b, strings.NewReader("<div><p>a</p></div>")), qt.IsNil)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Number of unchanged lines shown around each change.
+const diffContext = 3
+
+type diffOp int
+
+const (
+	diffEqual diffOp = iota
+	diffDelete
+	diffInsert
+)
+
+type diffLine struct {
+	op   diffOp
+	text []byte
+}
+
+// unifiedDiff returns a unified diff of a and b, or nil if they are equal.
+func unifiedDiff(nameA, nameB string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+
+	lines := diffLines(splitLines(a), splitLines(b))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", nameA, nameB)
+
+	// Line numbers (0-based) in a and b at the start of lines[i].
+	posA, posB := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, l := range lines {
+		posA[i+1], posB[i+1] = posA[i], posB[i]
+		if l.op != diffInsert {
+			posA[i+1]++
+		}
+		if l.op != diffDelete {
+			posB[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == diffEqual {
+			i++
+			continue
+		}
+
+		// Find the extent of this hunk, merging changes that are
+		// separated by less than 2*diffContext unchanged lines.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].op != diffEqual {
+				end++
+				continue
+			}
+			j := end
+			for j < len(lines) && lines[j].op == diffEqual {
+				j++
+			}
+			if j == len(lines) || j-end > 2*diffContext {
+				end += diffContext
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = j
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(posA[start], posA[end]-posA[start]),
+			hunkRange(posB[start], posB[end]-posB[start]))
+
+		for _, l := range lines[start:end] {
+			switch l.op {
+			case diffEqual:
+				buf.WriteByte(' ')
+			case diffDelete:
+				buf.WriteByte('-')
+			case diffInsert:
+				buf.WriteByte('+')
+			}
+			buf.Write(l.text)
+			if !bytes.HasSuffix(l.text, []byte("\n")) {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+
+	return buf.Bytes()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// splitLines splits b after each newline, keeping the newlines.
+func splitLines(b []byte) [][]byte {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b using
+// Myers' O(ND) algorithm.
+func diffLines(a, b [][]byte) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// The diagonals -d-1 to d+1 of v before each step d, the only ones
+	// read when walking back, at trace[d][k+d+1].
+	var trace [][]int
+
+Search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break Search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script.
+	var lines []diffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k] < v[d+k+2]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[d+1+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			lines = append(lines, diffLine{diffEqual, a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				lines = append(lines, diffLine{diffInsert, b[y]})
+			} else {
+				x--
+				lines = append(lines, diffLine{diffDelete, a[x]})
+			}
+		}
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines
+}
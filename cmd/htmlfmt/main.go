@@ -0,0 +1,221 @@
+// Command htmlfmt formats HTML files.
+//
+// Without an explicit path, it processes the standard input. Given a file,
+// it operates on that file; given a directory, it operates on all .html and
+// .htm files in that directory, recursively.
+// By default, htmlfmt prints the reformatted sources to standard output.
+//
+// Usage:
+//
+//	htmlfmt [flags] [path ...]
+//
+// The flags are:
+//
+//	-d
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different than htmlfmt's, print diffs
+//		to standard output.
+//	-l
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different from htmlfmt's, print its name
+//		to standard output.
+//	-w
+//		Do not print reformatted sources to standard output.
+//		If a file's formatting is different from htmlfmt's, overwrite it
+//		with htmlfmt's version.
+//	-tab string
+//		The string used for one level of indentation. The escape
+//		sequence \t can be used for a tab character (default two spaces).
+//	-newline-placeholder string
+//		Attribute name marking void elements that should only produce
+//		a newline, see htmlfmt.WithNewlineAttributePlaceholder.
+//
+// The exit status is 0 on success, 1 if -l or -d (without -w) found files
+// that are not formatted, and 2 on errors.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bep/htmlfmt"
+)
+
+var (
+	// main operation modes
+	list   = flag.Bool("l", false, "list files whose formatting differs from htmlfmt's")
+	write  = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff = flag.Bool("d", false, "display diffs instead of rewriting files")
+
+	// formatting options
+	tab                = flag.String("tab", "  ", `indentation string; \t is accepted for a tab character`)
+	newlinePlaceholder = flag.String("newline-placeholder", "", "attribute name marking void elements that only produce a newline")
+)
+
+const (
+	exitOK          = 0
+	exitUnformatted = 1
+	exitError       = 2
+)
+
+var (
+	exitCode  = exitOK
+	formatter *htmlfmt.Formatter
+)
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	var fe *htmlfmt.FormatError
+	if errors.As(err, &fe) && fe.Excerpt != "" {
+		fmt.Fprintf(os.Stderr, "\t%s\n", fe.Excerpt)
+	}
+	exitCode = exitError
+}
+
+func reportUnformatted() {
+	if exitCode == exitOK && (*list || *doDiff) && !*write {
+		exitCode = exitUnformatted
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: htmlfmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func isHTMLFile(f os.FileInfo) bool {
+	// ignore non-HTML files
+	name := f.Name()
+	if f.IsDir() || strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".html", ".htm":
+		return true
+	default:
+		return false
+	}
+}
+
+func newFormatter() *htmlfmt.Formatter {
+	options := []htmlfmt.Option{
+		htmlfmt.WithTab(strings.Replace(*tab, `\t`, "\t", -1)),
+	}
+	if *newlinePlaceholder != "" {
+		options = append(options, htmlfmt.WithNewlineAttributePlaceholder(*newlinePlaceholder))
+	}
+	return htmlfmt.New(options...)
+}
+
+// If in == nil, the source is the contents of the file with the given filename.
+func processFile(filename string, in io.Reader, out io.Writer, stdin bool) error {
+	var perm os.FileMode = 0644
+	if in == nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		in = f
+		perm = fi.Mode().Perm()
+	}
+
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := formatter.Format(&buf, bytes.NewReader(src)); err != nil {
+		var fe *htmlfmt.FormatError
+		if errors.As(err, &fe) {
+			fe.Filename = filename
+			return fe
+		}
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	res := buf.Bytes()
+
+	if !bytes.Equal(src, res) {
+		reportUnformatted()
+
+		// formatting has changed
+		if *list {
+			fmt.Fprintln(out, filename)
+		}
+		if *write {
+			if stdin {
+				return fmt.Errorf("can't use -w on stdin")
+			}
+			if err := ioutil.WriteFile(filename, res, perm); err != nil {
+				return err
+			}
+		}
+		if *doDiff {
+			out.Write(unifiedDiff(filename+".orig", filename, src, res))
+		}
+	}
+
+	if !*list && !*write && !*doDiff {
+		_, err = out.Write(res)
+	}
+
+	return err
+}
+
+func visitFile(path string, f os.FileInfo, err error) error {
+	if err == nil && isHTMLFile(f) {
+		err = processFile(path, nil, os.Stdout, false)
+	}
+	// Don't complain if a file was deleted in the meantime (i.e.
+	// the directory changed concurrently while running htmlfmt).
+	if err != nil && !os.IsNotExist(err) {
+		report(err)
+	}
+	return nil
+}
+
+func walkDir(path string) {
+	filepath.Walk(path, visitFile)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	formatter = newFormatter()
+
+	if flag.NArg() == 0 {
+		if err := processFile("<standard input>", os.Stdin, os.Stdout, true); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+
+	for i := 0; i < flag.NArg(); i++ {
+		path := flag.Arg(i)
+		switch dir, err := os.Stat(path); {
+		case err != nil:
+			report(err)
+		case dir.IsDir():
+			walkDir(path)
+		default:
+			if err := processFile(path, nil, os.Stdout, false); err != nil {
+				report(err)
+			}
+		}
+	}
+
+	os.Exit(exitCode)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bep/htmlfmt"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestProcessFile(t *testing.T) {
+	c := qt.New(t)
+
+	formatter = newFormatter()
+
+	process := func(c *qt.C, l, w, d bool, filename, src string) string {
+		*list, *write, *doDiff = l, w, d
+		exitCode = exitOK
+		defer func() { *list, *write, *doDiff = false, false, false }()
+		var out bytes.Buffer
+		var in *strings.Reader
+		if filename == "" {
+			in = strings.NewReader(src)
+			c.Assert(processFile("<standard input>", in, &out, true), qt.IsNil)
+		} else {
+			c.Assert(processFile(filename, nil, &out, false), qt.IsNil)
+		}
+		return out.String()
+	}
+
+	c.Run("Stdin", func(c *qt.C) {
+		c.Assert(process(c, false, false, false, "", "<div><p>AAA</p></div>"), qt.Equals, "<div>\n  <p>AAA</p>\n</div>")
+		c.Assert(exitCode, qt.Equals, exitOK)
+	})
+
+	c.Run("List", func(c *qt.C) {
+		c.Assert(process(c, true, false, false, "", "<div><p>AAA</p></div>"), qt.Equals, "<standard input>\n")
+		c.Assert(exitCode, qt.Equals, exitUnformatted)
+		c.Assert(process(c, true, false, false, "", "<div>\n  <p>AAA</p>\n</div>"), qt.Equals, "")
+		c.Assert(exitCode, qt.Equals, exitOK)
+	})
+
+	c.Run("Diff", func(c *qt.C) {
+		c.Assert(process(c, false, false, true, "", "<div><p>AAA</p></div>\n"), qt.Equals,
+			"--- <standard input>.orig\n+++ <standard input>\n@@ -1 +1,3 @@\n-<div><p>AAA</p></div>\n+<div>\n+  <p>AAA</p>\n+</div>\n")
+		c.Assert(exitCode, qt.Equals, exitUnformatted)
+	})
+
+	c.Run("Write", func(c *qt.C) {
+		dir, err := ioutil.TempDir("", "htmlfmt")
+		c.Assert(err, qt.IsNil)
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "index.html")
+		c.Assert(ioutil.WriteFile(filename, []byte("<div><p>AAA</p></div>"), 0600), qt.IsNil)
+
+		c.Assert(process(c, false, true, false, filename, ""), qt.Equals, "")
+		c.Assert(exitCode, qt.Equals, exitOK)
+		b, err := ioutil.ReadFile(filename)
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(b), qt.Equals, "<div>\n  <p>AAA</p>\n</div>")
+	})
+
+	c.Run("Error", func(c *qt.C) {
+		defer func() { formatter = newFormatter() }()
+		formatter = htmlfmt.New(htmlfmt.WithNewlineAttributePlaceholder("newline"))
+		err := processFile("<standard input>", strings.NewReader("<p>a</p>\n<div newline/>"), ioutil.Discard, true)
+		c.Assert(err, qt.ErrorMatches, "<standard input>:2:1: newline attributes is for void attributes only")
+		var fe *htmlfmt.FormatError
+		c.Assert(errors.As(err, &fe), qt.IsTrue)
+		c.Assert(fe.Excerpt, qt.Equals, "<div newline/>")
+	})
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(unifiedDiff("a", "b", []byte("same\n"), []byte("same\n")), qt.IsNil)
+	c.Assert(string(unifiedDiff("a", "b", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"), []byte("1\n2\n3\n4\nfive\n6\n7\n8\n9\n"))), qt.Equals,
+		"--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n")
+	c.Assert(string(unifiedDiff("a", "b", []byte("a"), []byte("b"))), qt.Equals,
+		"--- a\n+++ b\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n")
+}
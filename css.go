@@ -0,0 +1,216 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"strings"
+)
+
+// WithCSSFormatter configures the formatter to format the content of
+// <style> elements and style attributes as CSS, see CSSFormatter.
+// Text formatters set with WithTextFormatters take precedence.
+func WithCSSFormatter() Option {
+	return func(f *Formatter) { f.formatCSS = true }
+}
+
+// CSSFormatter returns a TextFormatter for style sheets, indenting with tab.
+//
+// Rules, at-rules and declarations are written one per line, indented by
+// the braces around them, and the whitespace inside them is collapsed, e.g.
+// "color:red" becomes "color: red". Comments and strings are kept as is,
+// and a blank line between two rules is preserved.
+// Style sheets with unbalanced braces are returned unchanged.
+func CSSFormatter(tab string) TextFormatter {
+	tabStr := []byte(tab)
+	return func(text []byte, depth int) []byte {
+		lines, ok := parseCSS(text, false)
+		if !ok {
+			return text
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+
+		var b bytes.Buffer
+		for _, l := range lines {
+			if l.blank {
+				b.WriteByte('\n')
+			}
+			b.WriteByte('\n')
+			b.Write(bytes.Repeat(tabStr, depth+1+l.level))
+			b.Write(l.text)
+		}
+		b.WriteByte('\n')
+		b.Write(bytes.Repeat(tabStr, depth))
+		return b.Bytes()
+	}
+}
+
+// formatStyles formats the value of any style attribute in a on one line,
+// e.g. "color:red;margin : 0" becomes "color: red; margin: 0".
+func (a Attributes) formatStyles() {
+	for i, attr := range a {
+		if attr.Key != "style" || attr.Value == "" || hasPlaceholder(attr.Value) {
+			continue
+		}
+		lines, ok := parseCSS([]byte(attr.Value), true)
+		if !ok {
+			continue
+		}
+		parts := make([]string, len(lines))
+		for j, l := range lines {
+			parts[j] = string(l.text)
+		}
+		if v := strings.Join(parts, " "); v != attr.Value {
+			a[i].Value = v
+		}
+	}
+}
+
+// cssLine is a line of formatted CSS, e.g. a declaration, at the given
+// nesting level.
+type cssLine struct {
+	level int
+	text  []byte
+	blank bool // Preceded by a blank line.
+}
+
+// parseCSS splits src into the lines of the formatted style sheet, or the
+// declarations of a style attribute if inline is set.
+// It returns false if src could not be formatted, e.g. with unbalanced
+// braces or, if inline, braces at all.
+func parseCSS(src []byte, inline bool) ([]cssLine, bool) {
+	var (
+		lines    []cssLine
+		seg      []byte // The rule or declaration being read.
+		space    bool   // Whitespace before the next part of seg.
+		newlines int    // Newlines in the source before seg.
+		level    int
+		parens   int
+	)
+
+	emit := func(text []byte, declaration bool) {
+		if declaration {
+			text = formatDeclaration(text)
+		}
+		blank := newlines > 1 && len(lines) > 0 && !bytes.HasSuffix(lines[len(lines)-1].text, []byte("{")) && !bytes.Equal(text, []byte("}"))
+		lines = append(lines, cssLine{level: level, text: text, blank: blank})
+		seg, space, newlines = nil, false, 0
+	}
+
+	add := func(b []byte) {
+		if space && len(seg) > 0 {
+			seg = append(seg, ' ')
+		}
+		space = false
+		seg = append(seg, b...)
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end == -1 {
+				end = len(src)
+			} else {
+				end += i + 4
+			}
+			if len(seg) == 0 {
+				emit(src[i:end], false)
+			} else {
+				add(src[i:end])
+			}
+			i = end
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(src) && src[j] == c {
+				j++
+			}
+			if j > len(src) {
+				j = len(src)
+			}
+			add(src[i:j])
+			i = j
+		case isTagSpace(c):
+			if len(seg) > 0 {
+				space = true
+			} else if c == '\n' {
+				newlines++
+			}
+			i++
+		case c == '(' || c == ')':
+			if c == '(' {
+				parens++
+			} else if parens > 0 {
+				parens--
+			}
+			add(src[i : i+1])
+			i++
+		case parens > 0:
+			add(src[i : i+1])
+			i++
+		case c == '{':
+			if inline {
+				return nil, false
+			}
+			space = len(seg) > 0
+			add([]byte("{"))
+			emit(seg, false)
+			level++
+			i++
+		case c == ';':
+			if len(seg) > 0 {
+				seg = append(seg, ';')
+				emit(seg, level > 0 || inline)
+			}
+			i++
+		case c == '}':
+			if inline || level == 0 {
+				return nil, false
+			}
+			if len(seg) > 0 {
+				emit(seg, true)
+			}
+			level--
+			emit([]byte("}"), false)
+			i++
+		default:
+			add(src[i : i+1])
+			i++
+		}
+	}
+
+	if level != 0 {
+		return nil, false
+	}
+	if len(seg) > 0 {
+		emit(seg, inline)
+	}
+
+	return lines, true
+}
+
+// formatDeclaration writes the declaration d, e.g. "color :red;", with
+// one space after the colon.
+func formatDeclaration(d []byte) []byte {
+	if d[0] == '@' {
+		return d
+	}
+	i := bytes.IndexByte(d, ':')
+	if i <= 0 {
+		return d
+	}
+	b := append(append([]byte(nil), bytes.TrimSpace(d[:i])...), ':')
+	if value := bytes.TrimSpace(d[i+1:]); len(value) > 0 && value[0] != ';' {
+		b = append(append(b, ' '), value...)
+	} else {
+		b = append(b, value...)
+	}
+	return b
+}
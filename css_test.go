@@ -0,0 +1,53 @@
+package htmlfmt
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCSSFormatter(t *testing.T) {
+	c := qt.New(t)
+
+	format := CSSFormatter("  ")
+
+	for _, test := range []struct {
+		src    string
+		depth  int
+		expect string
+	}{
+		{"a{color:red}", 0, "\n  a {\n    color: red\n  }\n"},
+		{"a , b{color : red;margin:0  auto;}", 1, "\n    a , b {\n      color: red;\n      margin: 0 auto;\n    }\n  "},
+		{"@media print{.a{display:none}}", 0, "\n  @media print {\n    .a {\n      display: none\n    }\n  }\n"},
+		{"a{b:c}\n\n\nd{e:f}", 0, "\n  a {\n    b: c\n  }\n\n  d {\n    e: f\n  }\n"},
+		{"/* { */\na{content:\"};\" /* x */}", 0, "\n  /* { */\n  a {\n    content: \"};\" /* x */\n  }\n"},
+		{"a{background:url(data:x;y)}", 0, "\n  a {\n    background: url(data:x;y)\n  }\n"},
+		{"@import 'a.css';@charset \"utf-8\";", 0, "\n  @import 'a.css';\n  @charset \"utf-8\";\n"},
+		{"a{&:hover{color:red}}", 0, "\n  a {\n    &:hover {\n      color: red\n    }\n  }\n"},
+		{" \n ", 0, ""},
+		// Unbalanced.
+		{"a{color:red", 0, "a{color:red"},
+		{"a}", 0, "a}"},
+	} {
+		c.Assert(string(format([]byte(test.src), test.depth)), qt.Equals, test.expect, qt.Commentf(test.src))
+	}
+}
+
+func TestFormatStyles(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		value  string
+		expect string
+	}{
+		{"color:red", "color: red"},
+		{"color:red;margin : 0  auto;", "color: red; margin: 0 auto;"},
+		{"font-family:'a;b'", "font-family: 'a;b'"},
+		{"a{b:c}", "a{b:c}"},
+	} {
+		a := Attributes{{Key: "style", Value: test.value, HasValue: true}, {Key: "title", Value: "a:b"}}
+		a.formatStyles()
+		c.Assert(a[0].Value, qt.Equals, test.expect)
+		c.Assert(a[1].Value, qt.Equals, "a:b")
+	}
+}
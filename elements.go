@@ -0,0 +1,192 @@
+package htmlfmt
+
+import "strings"
+
+// elementSet is a set of lower case element names.
+type elementSet map[string]bool
+
+func newElementSet(names ...string) elementSet {
+	s := make(elementSet, len(names))
+	for _, name := range names {
+		s[name] = true
+	}
+	return s
+}
+
+// with returns a copy of s with names added.
+// Names prefixed with "-" are removed.
+func (s elementSet) with(names []string) elementSet {
+	c := make(elementSet, len(s)+len(names))
+	for name := range s {
+		c[name] = true
+	}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if strings.HasPrefix(name, "-") {
+			delete(c, name[1:])
+		} else if name != "" {
+			c[name] = true
+		}
+	}
+	return c
+}
+
+// without returns a copy of s without the elements in other.
+func (s elementSet) without(other elementSet) elementSet {
+	c := make(elementSet, len(s))
+	for name := range s {
+		if !other[name] {
+			c[name] = true
+		}
+	}
+	return c
+}
+
+// elements holds the element classification tables used when formatting.
+type elements struct {
+	inline       elementSet
+	void         elementSet
+	preformatted elementSet
+	alwaysBreak  elementSet
+}
+
+// HTML5 content categories as defined in the WHATWG HTML Living Standard, see
+// https://html.spec.whatwg.org/multipage/dom.html#kinds-of-content
+// Autonomous custom elements (e.g. <my-button>) are flow and phrasing
+// content, but are not listed.
+var (
+	metadataContent = newElementSet(
+		"base", "link", "meta", "noscript", "script", "style", "template", "title",
+	)
+
+	flowContent = newElementSet(
+		"a", "abbr", "address", "area", "article", "aside", "audio", "b", "bdi",
+		"bdo", "blockquote", "br", "button", "canvas", "cite", "code", "data",
+		"datalist", "del", "details", "dfn", "dialog", "div", "dl", "em",
+		"embed", "fieldset", "figure", "footer", "form", "h1", "h2", "h3", "h4",
+		"h5", "h6", "header", "hgroup", "hr", "i", "iframe", "img", "input",
+		"ins", "kbd", "label", "link", "main", "map", "mark", "math", "menu",
+		"meta", "meter", "nav", "noscript", "object", "ol", "output", "p",
+		"picture", "pre", "progress", "q", "ruby", "s", "samp", "script",
+		"search", "section", "select", "slot", "small", "span", "strong", "sub",
+		"sup", "svg", "table", "template", "textarea", "time", "u", "ul", "var",
+		"video", "wbr",
+	)
+
+	sectioningContent = newElementSet("article", "aside", "nav", "section")
+
+	headingContent = newElementSet("h1", "h2", "h3", "h4", "h5", "h6", "hgroup")
+
+	phrasingContent = newElementSet(
+		"a", "abbr", "area", "audio", "b", "bdi", "bdo", "br", "button",
+		"canvas", "cite", "code", "data", "datalist", "del", "dfn", "em",
+		"embed", "i", "iframe", "img", "input", "ins", "kbd", "label", "link",
+		"map", "mark", "math", "meta", "meter", "noscript", "object", "output",
+		"picture", "progress", "q", "ruby", "s", "samp", "script", "select",
+		"slot", "small", "span", "strong", "sub", "sup", "svg", "template",
+		"textarea", "time", "u", "var", "video", "wbr",
+	)
+
+	embeddedContent = newElementSet(
+		"audio", "canvas", "embed", "iframe", "img", "math", "object", "picture",
+		"svg", "video",
+	)
+
+	interactiveContent = newElementSet(
+		"a", "audio", "button", "details", "embed", "iframe", "img", "input",
+		"label", "select", "textarea", "video",
+	)
+)
+
+// Element kinds, see
+// https://html.spec.whatwg.org/multipage/syntax.html#elements-2
+var (
+	voidElements = newElementSet(
+		"area", "base", "br", "col", "embed", "hr", "img", "input", "link",
+		"meta", "source", "track", "wbr",
+	)
+
+	rawTextElements = newElementSet("script", "style")
+
+	escapableRawTextElements = newElementSet("textarea", "title")
+)
+
+var defaultElements = &elements{
+	// Phrasing content that is rendered as part of the text flow.
+	// A <br> ends the line, so we do the same.
+	// The ruby annotations rt and rp are only allowed inside ruby.
+	inline: phrasingContent.
+		without(metadataContent).
+		with([]string{"-br", "rt", "rp"}),
+	// Obsolete elements that the HTML parser still treats as void.
+	void: voidElements.with([]string{"basefont", "bgsound", "command", "frame", "keygen", "param"}),
+	// The content of raw text elements (e.g. <script>) is reindented, see
+	// formatTextBlock and WithTextFormatters.
+	preformatted: newElementSet("pre", "textarea", "code"),
+	// Even for very short examples, we would not want these on one line.
+	alwaysBreak: newElementSet("html", "body"),
+}
+
+func (e *elements) isInline(tag string) bool {
+	return e.inline[tag]
+}
+
+func (e *elements) isPreformatted(tag string) bool {
+	return e.preformatted[tag]
+}
+
+func (e *elements) isVoid(tag string) bool {
+	return e.void[tag]
+}
+
+func (e *elements) shouldAlwaysHaveNewlineAppended(tag string) bool {
+	return e.alwaysBreak[tag]
+}
+
+// WithInlineElements configures the elements formatted as inline elements
+// (e.g. <span>), i.e. kept on the same line as the surrounding text.
+//
+// The names are added to the defaults, names prefixed with "-" are removed,
+// e.g. WithInlineElements("x-icon", "my-button", "-a").
+func WithInlineElements(names ...string) Option {
+	return func(f *Formatter) {
+		f.elements = f.elements.clone()
+		f.elements.inline = f.elements.inline.with(names)
+	}
+}
+
+// WithVoidElements configures the void elements (e.g. <br>), i.e. elements
+// without an end tag.
+// The names are added to or removed from the defaults as in WithInlineElements.
+func WithVoidElements(names ...string) Option {
+	return func(f *Formatter) {
+		f.elements = f.elements.clone()
+		f.elements.void = f.elements.void.with(names)
+	}
+}
+
+// WithPreformattedElements configures the elements (e.g. <pre>) whose
+// content is left untouched.
+// The names are added to or removed from the defaults as in WithInlineElements.
+func WithPreformattedElements(names ...string) Option {
+	return func(f *Formatter) {
+		f.elements = f.elements.clone()
+		f.elements.preformatted = f.elements.preformatted.with(names)
+	}
+}
+
+// WithAlwaysBreakElements configures the elements (e.g. <body>) whose
+// content is always wrapped and indented, even if short.
+// The names are added to or removed from the defaults as in WithInlineElements.
+func WithAlwaysBreakElements(names ...string) Option {
+	return func(f *Formatter) {
+		f.elements = f.elements.clone()
+		f.elements.alwaysBreak = f.elements.alwaysBreak.with(names)
+	}
+}
+
+// clone returns a shallow copy of e.
+func (e *elements) clone() *elements {
+	c := *e
+	return &c
+}
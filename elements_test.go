@@ -0,0 +1,83 @@
+package htmlfmt
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestElementSetWith(t *testing.T) {
+	c := qt.New(t)
+
+	s := newElementSet("a", "b")
+	s2 := s.with([]string{"C", "-a", " d ", "-x", ""})
+	c.Assert(s2, qt.DeepEquals, newElementSet("b", "c", "d"))
+	c.Assert(s, qt.DeepEquals, newElementSet("a", "b"))
+}
+
+func TestElementOptions(t *testing.T) {
+	c := qt.New(t)
+
+	f := New(WithInlineElements("x-icon", "-a"), WithAlwaysBreakElements("ul"))
+	c.Assert(f.elements.isInline("x-icon"), qt.IsTrue)
+	c.Assert(f.elements.isInline("a"), qt.IsFalse)
+	c.Assert(f.elements.isInline("span"), qt.IsTrue)
+	c.Assert(f.elements.shouldAlwaysHaveNewlineAppended("ul"), qt.IsTrue)
+
+	// The defaults are not modified.
+	c.Assert(defaultElements.isInline("x-icon"), qt.IsFalse)
+	c.Assert(defaultElements.isInline("a"), qt.IsTrue)
+	c.Assert(defaultElements.shouldAlwaysHaveNewlineAppended("ul"), qt.IsFalse)
+}
+
+// The lists below are copied from the WHATWG HTML Living Standard, see
+// https://html.spec.whatwg.org/multipage/indices.html#element-content-categories
+func TestContentCategories(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		name   string
+		got    elementSet
+		expect string
+	}{
+		{"metadata", metadataContent, "base link meta noscript script style template title"},
+		{"flow", flowContent, "a abbr address area article aside audio b bdi bdo blockquote br button canvas cite code data datalist del details dfn dialog div dl em embed fieldset figure footer form h1 h2 h3 h4 h5 h6 header hgroup hr i iframe img input ins kbd label link main map mark math menu meta meter nav noscript object ol output p picture pre progress q ruby s samp script search section select slot small span strong sub sup svg table template textarea time u ul var video wbr"},
+		{"sectioning", sectioningContent, "article aside nav section"},
+		{"heading", headingContent, "h1 h2 h3 h4 h5 h6 hgroup"},
+		{"phrasing", phrasingContent, "a abbr area audio b bdi bdo br button canvas cite code data datalist del dfn em embed i iframe img input ins kbd label link map mark math meta meter noscript object output picture progress q ruby s samp script select slot small span strong sub sup svg template textarea time u var video wbr"},
+		{"embedded", embeddedContent, "audio canvas embed iframe img math object picture svg video"},
+		{"interactive", interactiveContent, "a audio button details embed iframe img input label select textarea video"},
+		{"void", voidElements, "area base br col embed hr img input link meta source track wbr"},
+		{"raw text", rawTextElements, "script style"},
+		{"escapable raw text", escapableRawTextElements, "textarea title"},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			c.Assert(test.got, qt.DeepEquals, newElementSet(strings.Fields(test.expect)...))
+		})
+	}
+}
+
+func TestDefaultElements(t *testing.T) {
+	c := qt.New(t)
+
+	for _, name := range []string{
+		"a", "abbr", "b", "bdi", "bdo", "button", "cite", "code", "data", "del",
+		"dfn", "em", "i", "img", "input", "ins", "kbd", "label", "mark", "math",
+		"meter", "output", "picture", "progress", "q", "rp", "rt", "ruby", "s",
+		"samp", "select", "slot", "small", "span", "strong", "sub", "sup", "svg",
+		"time", "u", "var", "wbr",
+	} {
+		c.Assert(defaultElements.isInline(name), qt.IsTrue, qt.Commentf(name))
+	}
+
+	for _, name := range []string{
+		"acronym", "big", "tt", "br", "div", "p", "script", "link", "meta", "template", "section",
+	} {
+		c.Assert(defaultElements.isInline(name), qt.IsFalse, qt.Commentf(name))
+	}
+
+	for _, name := range []string{"br", "img", "param", "keygen"} {
+		c.Assert(defaultElements.isVoid(name), qt.IsTrue, qt.Commentf(name))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"regexp"
 	"strconv"
 	"sync"
@@ -21,24 +22,32 @@ var (
 	nonSpaceRe        = regexp.MustCompile(`\S`)
 )
 
+// ErrUnhandledToken is returned by Format for a token the formatter does
+// not know how to write. This is a bug in the formatter.
+var ErrUnhandledToken = errors.New("unhandled token")
+
 // Elements with length in bytes above this threshold will be wrapped
 // and indented. This includes the start/end tags.
 // This allows short blocks such as <div>Hi</div> to be kept on one line.
+// This is only used when no print width is configured, see WithPrintWidth.
 const sizeNewlineThreshold = 30
 
 // New HTML formatter.
 // Can be safely reused.
 func New(options ...Option) *Formatter {
 	f := &Formatter{
-		tabStr:  []byte("  "),
-		newline: []byte("\n"),
-		textFormatters: func(tag Tag) TextFormatter {
+		tabStr:   []byte("  "),
+		newline:  []byte("\n"),
+		elements: defaultElements,
+		textFormatters: func(tag Tag) ContextTextFormatter {
 			return nil
 		},
 	}
 	for _, option := range options {
 		option(f)
 	}
+	f.tabWidth = displayWidth(f.tabStr)
+	f.builtinFormatters = f.builtinTextFormatters()
 	return f
 }
 
@@ -50,23 +59,148 @@ func New(options ...Option) *Formatter {
 // produced by "<br newline/>".
 //
 // Note that this is only supported for void/self closing elements.
+//
+// Deprecated: Use WithOpaquePattern or Formatter.FormatRegions with
+// OpaqueBlock.
 func WithNewlineAttributePlaceholder(attribute string) Option {
 	return func(f *Formatter) { f.newlineAttributePlaceholder = attribute }
 }
 
+// WithAttributeOrder configures the formatter to sort the attributes of
+// start tags by the given patterns, e.g.
+//
+//	WithAttributeOrder("id", "name", "class", "data-*", "aria-*")
+//
+// Attributes are ordered by the first pattern they match (see path.Match
+// for the pattern syntax). Attributes matching the same pattern, and
+// attributes not matching any pattern (sorted last), are sorted by key.
+//
+// The default is to preserve the source order.
+func WithAttributeOrder(patterns ...string) Option {
+	return func(f *Formatter) { f.attributeOrder = patterns }
+}
+
+// QuoteStyle configures the quoting of attribute values, see WithQuoteStyle.
+type QuoteStyle int
+
+const (
+	// QuotePreserve keeps the quotes as written in the source.
+	QuotePreserve QuoteStyle = iota
+
+	// QuoteDouble quotes all values with double quotes, or single quotes
+	// if the value contains double quotes only.
+	QuoteDouble
+
+	// QuoteSingle quotes all values with single quotes, or double quotes
+	// if the value contains single quotes only.
+	QuoteSingle
+)
+
+// WithQuoteStyle configures the formatter to normalize the quoting of
+// attribute values, including quoting unquoted values.
+// The default is QuotePreserve.
+func WithQuoteStyle(style QuoteStyle) Option {
+	return func(f *Formatter) { f.quoteStyle = style }
+}
+
+// BooleanAttributeStyle configures how boolean attributes (e.g. disabled)
+// are written, see WithBooleanAttributeStyle.
+type BooleanAttributeStyle int
+
+const (
+	// BooleanAttributePreserve keeps boolean attributes as written in the source.
+	BooleanAttributePreserve BooleanAttributeStyle = iota
+
+	// BooleanAttributeMinimized writes boolean attributes without a value,
+	// e.g. disabled.
+	BooleanAttributeMinimized
+
+	// BooleanAttributeEmpty writes boolean attributes with an empty value,
+	// e.g. disabled="".
+	BooleanAttributeEmpty
+
+	// BooleanAttributeName writes boolean attributes with the attribute
+	// name as value, e.g. disabled="disabled".
+	BooleanAttributeName
+)
+
+// WithBooleanAttributeStyle configures the formatter to normalize boolean
+// attributes. The default is BooleanAttributePreserve.
+func WithBooleanAttributeStyle(style BooleanAttributeStyle) Option {
+	return func(f *Formatter) { f.booleanAttributeStyle = style }
+}
+
+// WithBracketSameLine configures whether the closing bracket of a start tag
+// wrapped with one attribute per line (see WithPrintWidth) is put on the
+// last attribute line instead of on its own line.
+func WithBracketSameLine(sameLine bool) Option {
+	return func(f *Formatter) { f.bracketSameLine = sameLine }
+}
+
 // WithTab configures the formatter use tab as indentation.
 func WithTab(tab string) Option { return func(f *Formatter) { f.tabStr = []byte(tab) } }
 
+// WithPrintWidth configures the maximum line width in display columns.
+//
+// An element is kept on one line if it fits within width when rendered
+// at its indentation level, otherwise its content is wrapped and indented.
+// Start tags wider than width are wrapped with one attribute per line.
+// Wide characters (e.g. CJK) count as two columns.
+//
+// The default (0) keeps elements shorter than 30 bytes on one line,
+// regardless of indentation.
+func WithPrintWidth(width int) Option {
+	return func(f *Formatter) { f.printWidth = width }
+}
+
+// WithWarningHandler configures the formatter to call handler for
+// problems in the source that did not stop it from formatting, e.g.
+// invalid JSON left as is, see WithJSONFormatter.
+func WithWarningHandler(handler func(err error)) Option {
+	return func(f *Formatter) { f.warningHandler = handler }
+}
+
 // WithTextFormatters configures the formatter to use the provided lookup
 // func to find a formatter for a block of text inside tag (e.g. a JavaScript formatter).
 func WithTextFormatters(lookup func(tag Tag) TextFormatter) Option {
+	return WithContextTextFormatters(func(tag Tag) ContextTextFormatter {
+		if formatText := lookup(tag); formatText != nil {
+			return formatText
+		}
+		return nil
+	})
+}
+
+// WithContextTextFormatters is WithTextFormatters for formatters that
+// need more context than the depth or may fail, e.g.
+// TextFormatterRegistry.Lookup.
+func WithContextTextFormatters(lookup func(tag Tag) ContextTextFormatter) Option {
 	return func(f *Formatter) { f.textFormatters = lookup }
 }
 
+// textFormatter returns the formatter for the text inside tag, if any.
+func (f *Formatter) textFormatter(tag Tag) ContextTextFormatter {
+	if formatText := f.textFormatters(tag); formatText != nil {
+		return formatText
+	}
+	return f.builtinFormatters.Lookup(tag)
+}
+
 // Attribute represents an HTML attribute.
 type Attribute struct {
 	Key   string
 	Value string
+
+	// Quote is the quote character around the value, one of '"', '\''
+	// or 0 for unquoted values.
+	Quote byte
+
+	// HasValue is set if the attribute has a value, e.g. false
+	// for <input disabled> and true for <input disabled="">.
+	HasValue bool
+
+	// The attribute as written in the source, if parsed.
+	src attributeSource
 }
 
 // IsZero returns whenter a is zero.
@@ -93,19 +227,83 @@ type Formatter struct {
 	// options
 	tabStr                      []byte
 	newline                     []byte
-	textFormatters              func(tag Tag) TextFormatter
+	textFormatters              func(tag Tag) ContextTextFormatter
 	newlineAttributePlaceholder string
+	printWidth                  int
+	bracketSameLine             bool
+	attributeOrder              []string
+	quoteStyle                  QuoteStyle
+	booleanAttributeStyle       BooleanAttributeStyle
+	elements                    *elements
+	whitespace                  WhitespaceSensitivity
+	template                    *TemplateSyntax
+	validateTemplates           bool
+	opaquePatterns              []opaquePattern
+	formatCSS                   bool
+	formatJS                    bool
+	formatJSON                  bool
+	warningHandler              func(err error)
+	tagCheck                    TagCheck
+	impliedEndTags              bool
+
+	// The built-in text formatters enabled, e.g. with WithCSSFormatter.
+	builtinFormatters *TextFormatterRegistry
+
+	// The display width of tabStr.
+	tabWidth int
 }
 
-// Format formats src and writes the result to dst.
+// Format formats src and writes the result to dst. If formatting fails,
+// e.g. in a text formatter, nothing is written.
+//
+// Parts of src can be left as is using comments:
+//
+//	<!-- htmlfmt-ignore -->          the next element or text
+//	<!-- htmlfmt-ignore-start -->    everything up to <!-- htmlfmt-ignore-end -->
+//	<!-- htmlfmt:off -->             the whole document
 func (f *Formatter) Format(dst io.Writer, src io.Reader) error {
-	p := newParser(src, f.tabStr)
+	if f.validateTemplates && (f.template == nil || !f.template.goTemplate) {
+		return &FormatError{Err: errors.New("template validation requires Go templates")}
+	}
+	if f.template != nil || len(f.opaquePatterns) > 0 {
+		return f.formatPlaceholders(dst, src, nil)
+	}
+
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		return &FormatError{Err: err}
+	}
 
+	p := f.newParser(b)
 	tokens, err := p.parse()
 	if err != nil {
+		return newFormatError(b, p.pos, err)
+	}
+	if err := f.reportTagErrors(b, p); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := f.formatTokens(&buf, tokens, p.off, b); err != nil {
 		return err
 	}
+	if _, err := dst.Write(buf.Bytes()); err != nil {
+		return &FormatError{Err: err}
+	}
+	return nil
+}
+
+// newParser returns a parser for src configured by f.
+func (f *Formatter) newParser(src []byte) *parser {
+	p := newParser(bytes.NewReader(src), f.tabStr, f.elements)
+	p.checkTags = f.tagCheck != TagCheckOff
+	p.impliedEndTags = f.impliedEndTags
+	return p
+}
 
+// formatTokens writes the tokens parsed from src to dst.
+// If off is set, see directiveOff, they are written as is.
+func (f *Formatter) formatTokens(dst io.Writer, tokens tokens, off bool, src []byte) error {
 	iter := &tokenIterator{
 		tokens: tokens,
 		pos:    -1,
@@ -118,23 +316,34 @@ func (f *Formatter) Format(dst io.Writer, src io.Reader) error {
 		enableDebug: false,
 	}
 
-	var formatText TextFormatter = nil
-	var inPre bool
+	if off {
+		// <!-- htmlfmt:off -->
+		for _, t := range tokens {
+			w.write(t.raw)
+		}
+		return w.err
+	}
+
+	var (
+		formatText ContextTextFormatter
+		textCtx    TextContext
+		ancestors  []*token // The open elements.
+	)
 
 	for {
 		curr := iter.Next()
-		if curr == nil {
+		if curr == nil || w.err != nil {
 			break
 		}
 
-		if inPre && !curr.inPre {
-			w.write(curr.raw)
-			continue
-		}
-
 		prev := iter.Prev()
 		next := iter.Peek()
 
+		if curr.verbatim {
+			w.writeVerbatim(prev, curr, next)
+			continue
+		}
+
 		if curr.text.isWhitespaceOnly {
 			if prev == nil && leadingNewlineRe.Match(curr.raw) {
 				// Preserve one leading newline.
@@ -146,17 +355,25 @@ func (f *Formatter) Format(dst io.Writer, src io.Reader) error {
 				w.newline()
 			}
 
-			if prev == nil || !prev.inPre {
-				// Nothing more to do.
-				continue
+			if prev != nil && next != nil && w.f.whitespace != WhitespaceIgnore && w.newlineDepth == 0 && !w.spaceOrBoundary(prev, -1) && !w.boundary(next, 1) {
+				// Significant whitespace, e.g. between two inline elements.
+				if bytes.IndexByte(curr.raw, '\n') != -1 {
+					if w.newline() {
+						w.tab()
+					}
+				} else {
+					w.write([]byte{' '})
+				}
 			}
+			// Nothing more to do.
+			continue
 		}
 
 		var newlineAttribute bool
 		if curr.typ != html.TextToken && f.newlineAttributePlaceholder != "" {
 			newlineAttribute = !curr.tag.Attributes.ByKey(f.newlineAttributePlaceholder).IsZero()
 			if newlineAttribute && !curr.isVoid() {
-				return errors.New("newline attributes is for void attributes only")
+				return newFormatError(src, curr.pos, errors.New("newline attributes is for void attributes only"))
 			}
 		}
 
@@ -168,52 +385,76 @@ func (f *Formatter) Format(dst io.Writer, src io.Reader) error {
 
 		switch curr.typ {
 		case html.StartTagToken:
-			if curr.inPre {
-				inPre = true
-				w.write(curr.raw)
-				continue
-			}
-
 			// A text formatter for e.g. JavaScript script tags currently assumes
 			// a single wrapped text element and any whitespace handling is
 			// delegated to the custom text formatter.
-			formatText = f.textFormatters(curr.tag)
+			formatText = f.textFormatter(curr.tag)
+			if formatText != nil {
+				textCtx = TextContext{
+					Tag:        curr.tag,
+					Ancestors:  make([]Tag, len(ancestors)),
+					Tab:        string(f.tabStr),
+					Newline:    string(f.newline),
+					PrintWidth: f.printWidth,
+				}
+				for i, t := range ancestors {
+					textCtx.Ancestors[i] = t.tag
+				}
+			}
+			if !curr.isVoid() {
+				ancestors = append(ancestors, curr)
+			}
 
 			var needsNewlineAppended bool
 
+			startTag, wrapped := w.startTag(curr)
+
 			if formatText == nil {
-				needsNewlineAppended = curr.needsNewlineAppended()
+				needsNewlineAppended = curr.needsNewlineAppended(w.depth*f.tabWidth, f.printWidth)
+				if wrapped && len(curr.children) > 0 {
+					// Don't put content after a wrapped start tag.
+					needsNewlineAppended = true
+				}
+				if needsNewlineAppended && !w.canWrap(curr) {
+					needsNewlineAppended = false
+				}
 				if needsNewlineAppended {
 					curr.indented = true
 					w.depth++
-				} else if prev != nil && next != nil && curr.isVoid() {
+				} else if prev != nil && next != nil && curr.isVoid() && curr.isBlock() && w.canBreak(prev, curr) {
 					if w.newline() {
 						w.tab()
 					}
 				}
 			}
 
-			w.write(curr.raw)
+			w.write(startTag)
 
 			if formatText == nil {
-				if needsNewlineAppended || (prev != nil && next != nil && curr.isVoid()) {
+				if needsNewlineAppended || (prev != nil && next != nil && curr.isVoid() && curr.isBlock() && w.canBreak(curr, next)) {
 					if w.newline() {
 						w.tab()
 					}
 				}
 			}
-		case html.SelfClosingTagToken, html.CommentToken, html.DoctypeToken:
+		case html.SelfClosingTagToken:
+			startTag, _ := w.startTag(curr)
+			w.write(startTag)
+			if prev == nil && next != nil && w.canBreak(curr, next) {
+				w.newline()
+			}
+		case html.CommentToken, html.DoctypeToken:
 			w.write(curr.raw)
-			if prev == nil && next != nil {
+			if prev == nil && next != nil && w.canBreak(curr, next) {
 				w.newline()
 			}
 		case html.EndTagToken:
-			if curr.inPre {
-				inPre = false
-				w.write(curr.raw)
-				continue
-			}
-			if formatText == nil {
+			if curr.implied && !f.impliedEndTags {
+				// Omitted in the source, see parser.closeImplied.
+				if curr.isStartIndented() && w.depth > 0 {
+					w.depth--
+				}
+			} else if formatText == nil {
 				if curr.isStartIndented() {
 					n := w.newline()
 					w.depth--
@@ -227,17 +468,24 @@ func (f *Formatter) Format(dst io.Writer, src io.Reader) error {
 			}
 
 			w.write(curr.raw)
+			formatText = nil
+			for i := len(ancestors) - 1; i >= 0; i-- {
+				if ancestors[i] == curr.startElement {
+					ancestors = ancestors[:i]
+					break
+				}
+			}
 
-			if next != nil && !next.isInline() {
+			if next != nil && (curr.isBlock() || !next.isInline()) && w.canBreak(curr, next) {
 				nextStart := iter.PeekStart()
-				if nextStart != nil && curr.depth == nextStart.depth {
+				if nextStart != nil && curr.depth == nextStart.depth && !iter.peekClosing() {
 					if w.newline() {
 						w.tab()
 					}
 				}
 			}
 		case html.TextToken:
-			if prev != nil && (prev.typ == html.EndTagToken || prev.isVoid()) && prev.isBlock() {
+			if prev != nil && (prev.typ == html.EndTagToken || prev.isVoid()) && prev.isBlock() && w.canBreak(prev, curr) {
 				if w.newline() {
 					w.tab()
 				}
@@ -251,9 +499,14 @@ func (f *Formatter) Format(dst io.Writer, src io.Reader) error {
 			}
 
 			if formatText != nil {
-				w.write(formatText(curr.raw, w.depth))
+				textCtx.Depth, textCtx.Offset = w.depth, curr.pos.offset
+				b, err := formatText.FormatText(textCtx, curr.raw)
+				if err != nil {
+					return newFormatError(src, curr.pos, fmt.Errorf("formatting <%s>: %w", curr.tag.Name, err))
+				}
+				w.write(b)
 			} else {
-				w.handleTextToken(prev, curr, next)
+				w.defaultTextTokenHandler(prev, curr, next)
 			}
 
 			// Preserve one trailing newline.
@@ -262,12 +515,76 @@ func (f *Formatter) Format(dst io.Writer, src io.Reader) error {
 					w.tab()
 				}
 			}
+		case templateStartToken:
+			if w.canBreakAtAction(prev, curr) {
+				if w.newline() {
+					w.tab()
+				}
+			}
+
+			w.write(curr.raw)
+
+			if curr.needsNewlineAppended(w.depth*f.tabWidth, f.printWidth) && w.canWrap(curr) {
+				curr.indented = true
+				w.depth++
+				if w.newline() {
+					w.tab()
+				}
+			}
+		case templateElseToken:
+			if curr.isStartIndented() {
+				n := w.newline()
+				w.depth--
+				if n {
+					w.tab()
+				}
+			}
+
+			w.write(curr.raw)
+
+			if curr.isStartIndented() {
+				w.depth++
+				if w.newline() {
+					w.tab()
+				}
+			}
+		case templateEndToken:
+			if curr.isStartIndented() {
+				n := w.newline()
+				w.depth--
+				if w.depth < 0 {
+					w.depth = 0
+				}
+				if n {
+					w.tab()
+				}
+			}
+
+			w.write(curr.raw)
+
+			if w.canBreakAtAction(curr, next) && !iter.peekClosing() {
+				if w.newline() {
+					w.tab()
+				}
+			}
+		case opaqueBlockToken:
+			if prev != nil && w.newline() {
+				w.tab()
+			}
+
+			w.write(curr.raw)
+
+			if next != nil && !iter.peekClosing() {
+				if w.newline() {
+					w.tab()
+				}
+			}
 		default:
-			panic("Unhandled token")
+			return newFormatError(src, curr.pos, ErrUnhandledToken)
 		}
 	}
 
-	return nil
+	return w.err
 }
 
 // Option sets an option of the HTML formatter.
@@ -277,6 +594,14 @@ type Option func(f *Formatter)
 type Tag struct {
 	Name       string
 	Attributes Attributes
+
+	// SelfClosing is set for start tags with a trailing slash, e.g. <br/>.
+	SelfClosing bool
+
+	// The tag name as written in the source.
+	rawName string
+	// Whether the self-closing slash was preceded by whitespace, e.g. <br />.
+	spaceBeforeSlash bool
 }
 
 // IsZero returns whether t is zero.
@@ -288,6 +613,55 @@ func (t Tag) IsZero() bool {
 // tag, e.g. <script> blocks.
 type TextFormatter func(text []byte, depth int) []byte
 
+// FormatText implements ContextTextFormatter.
+func (f TextFormatter) FormatText(ctx TextContext, text []byte) ([]byte, error) {
+	return f(text, ctx.Depth), nil
+}
+
+// ContextTextFormatter formats the text inside an element, e.g. a
+// <script> block, see WithContextTextFormatters.
+//
+// The returned text is written as is between the start and end tags, so
+// it should include any line breaks and indentation around the text.
+type ContextTextFormatter interface {
+	FormatText(ctx TextContext, text []byte) ([]byte, error)
+}
+
+// TextFormatterFunc is a func implementing ContextTextFormatter.
+type TextFormatterFunc func(ctx TextContext, text []byte) ([]byte, error)
+
+// FormatText implements ContextTextFormatter.
+func (f TextFormatterFunc) FormatText(ctx TextContext, text []byte) ([]byte, error) {
+	return f(ctx, text)
+}
+
+// TextContext describes the text passed to a ContextTextFormatter.
+type TextContext struct {
+	// The element containing the text, e.g. <script>.
+	Tag Tag
+
+	// The elements containing Tag, outermost first.
+	Ancestors []Tag
+
+	// The depth of Tag, i.e. the number of Tab it is indented with.
+	Depth int
+
+	// The indentation and line break strings, see WithTab.
+	Tab     string
+	Newline string
+
+	// The maximum line width, see WithPrintWidth, or 0 if not set.
+	PrintWidth int
+
+	// The byte offset of the text in the source.
+	Offset int
+}
+
+// Raw returns the source of the current token.
+func (tok *parser) Raw() []byte {
+	return tok.raw
+}
+
 func (tok *parser) Next() html.TokenType {
 	typ := tok.Tokenizer.Next()
 
@@ -297,6 +671,9 @@ func (tok *parser) Next() html.TokenType {
 		tok.prevType = tok.currType
 	}
 	tok.currType = typ
+	// TagName and TagAttr lower case and unescape in the tokenizer's
+	// buffer, so keep the source of the token first.
+	tok.raw = append(tok.raw[:0], tok.Tokenizer.Raw()...)
 
 	var hasAttrs bool
 	if tok.currType != html.TextToken {
@@ -321,6 +698,20 @@ func (tok *parser) Next() html.TokenType {
 		}
 	}
 
+	if tok.currType == html.StartTagToken || tok.currType == html.SelfClosingTagToken {
+		var attrs []attributeSource
+		tok.tag.SelfClosing = tok.currType == html.SelfClosingTagToken
+		tok.tag.rawName, attrs, tok.tag.spaceBeforeSlash = scanTag(tok.Raw())
+		if len(attrs) == len(tok.tag.Attributes) {
+			for i, src := range attrs {
+				attr := &tok.tag.Attributes[i]
+				src.decoded = attr.Value
+				attr.Quote, attr.HasValue = src.quote, src.hasValue
+				attr.src = src
+			}
+		}
+	}
+
 	return tok.currType
 }
 
@@ -334,6 +725,9 @@ type writer struct {
 
 	depth        int
 	newlineDepth int
+
+	// The first error writing to dst. Nothing is written after it.
+	err error
 }
 
 func prepareText(inTxt, tabStr []byte) text {
@@ -358,7 +752,21 @@ func (w *writer) defaultTextTokenHandler(prev, curr, next *token) {
 	txt := curr.text
 	text := txt.b
 
-	if txt.hadTralingSpace && next != nil && next.typ == html.StartTagToken && next.isInline() {
+	var leadingSpace, trailingSpace bool
+	if w.f.whitespace == WhitespaceIgnore {
+		// An inline end tag or void element, e.g. </span> or <img>.
+		prevIsInlineEndTag := prev != nil && prev.isInline() && (prev.typ == html.EndTagToken || prev.typ == html.SelfClosingTagToken || prev.isVoid())
+		leadingSpace = prevIsInlineEndTag && txt.hadLeadingSpace
+		trailingSpace = txt.hadTralingSpace && next != nil && (next.typ == html.StartTagToken || next.typ == html.SelfClosingTagToken) && next.isInline()
+	} else {
+		// A run of whitespace renders as one space, written where the run
+		// starts, unless at the start of a line or next to a block
+		// boundary, where it is not rendered.
+		leadingSpace = txt.hadLeadingSpace && w.newlineDepth == 0 && !w.spaceOrBoundary(prev, -1)
+		trailingSpace = txt.hadTralingSpace && !w.boundary(next, 1) && !(next.typ == html.EndTagToken && next.isStartIndented())
+	}
+
+	if trailingSpace {
 		text = append(text, ' ')
 	}
 
@@ -366,27 +774,86 @@ func (w *writer) defaultTextTokenHandler(prev, curr, next *token) {
 		return
 	}
 
-	prevIsInlineEndTag := prev != nil && prev.typ == html.EndTagToken && prev.isInline()
+	if leadingSpace {
+		text = append([]byte{' '}, text...)
+	}
+
 	if txt.hasNewline {
-		if prevIsInlineEndTag {
-			if txt.hadLeadingSpace {
-				text = append([]byte{' '}, text...)
-			}
-		}
 		w.write(w.formatText(text))
 	} else {
-		if prevIsInlineEndTag && txt.hadLeadingSpace {
-			text = append([]byte{' '}, text...)
-		}
 		w.write(text)
 	}
 }
 
-func (w *writer) handleTextToken(prev, curr, next *token) {
-	if curr.inPre {
-		w.write(curr.raw)
-	} else {
-		w.defaultTextTokenHandler(prev, curr, next)
+// startTag renders the start tag of t from its Tag.
+// If it does not fit within the print width, it is wrapped with one
+// attribute per line and wrapped is set.
+func (w *writer) startTag(t *token) (b []byte, wrapped bool) {
+	// Attributes in template blocks are written as in the source.
+	if !t.tag.Attributes.hasPlaceholderKeys() {
+		if len(w.f.attributeOrder) > 0 {
+			t.tag.Attributes.sort(w.f.attributeOrder)
+		}
+		if w.f.booleanAttributeStyle != BooleanAttributePreserve {
+			t.tag.Attributes.normalizeBooleans(w.f.booleanAttributeStyle)
+		}
+		if w.f.formatCSS {
+			t.tag.Attributes.formatStyles()
+		}
+		switch w.f.quoteStyle {
+		case QuoteDouble:
+			t.tag.Attributes.normalizeQuotes('"')
+		case QuoteSingle:
+			t.tag.Attributes.normalizeQuotes('\'')
+		}
+	}
+
+	b = appendStartTag(nil, t.tag, []byte(" "), nil)
+
+	if w.f.printWidth <= 0 || len(t.tag.Attributes) == 0 {
+		return b, false
+	}
+
+	if w.depth*w.f.tabWidth+displayWidth(b) <= w.f.printWidth {
+		return b, false
+	}
+
+	return wrapStartTag(t.tag, w.f.tabStr, w.f.newline, w.depth, w.f.bracketSameLine), true
+}
+
+// writeVerbatim writes curr, e.g. a token inside <pre> or an ignored
+// region, as is. A line break in the source before or after an ignored
+// node is kept, and one is added before a block element following it.
+func (w *writer) writeVerbatim(prev, curr, next *token) {
+	if prev != nil && !prev.verbatim && prev.text.isWhitespaceOnly && bytes.IndexByte(prev.raw, '\n') != -1 {
+		if w.newline() {
+			w.tab()
+		}
+	}
+
+	w.write(curr.raw)
+
+	if next == nil || next.verbatim {
+		return
+	}
+	if next.text.isWhitespaceOnly && bytes.IndexByte(next.raw, '\n') != -1 {
+		nextStart := w.iter.PeekStart()
+		if nextStart != nil && curr.depth == nextStart.depth {
+			if w.newline() {
+				w.tab()
+			}
+		}
+		return
+	}
+
+	sibling := next
+	if next.text.isWhitespaceOnly {
+		sibling = w.iter.at(next.i + 1)
+	}
+	if sibling != nil && sibling.typ == html.StartTagToken && sibling.isBlock() && curr.depth == sibling.depth && w.canBreak(curr, sibling) {
+		if w.newline() {
+			w.tab()
+		}
 	}
 }
 
@@ -452,7 +919,7 @@ func (w *writer) newline() bool {
 	if w.enableDebug {
 		w.debug("newline")
 	}
-	w.mustWrite(w.f.newline)
+	w.writeRaw(w.f.newline)
 	return true
 }
 
@@ -460,14 +927,14 @@ func (w *writer) newlineForced() {
 	if w.enableDebug {
 		w.debug("newlineForced")
 	}
-	w.mustWrite(w.f.newline)
+	w.writeRaw(w.f.newline)
 }
 
 func (w *writer) tab() {
 	if w.enableDebug {
 		w.debug(fmt.Sprintf("tab(%d)", w.depth))
 	}
-	w.mustWrite(bytes.Repeat(w.f.tabStr, w.depth))
+	w.writeRaw(bytes.Repeat(w.f.tabStr, w.depth))
 }
 
 func (w *writer) write(p []byte) bool {
@@ -477,13 +944,16 @@ func (w *writer) write(p []byte) bool {
 		}
 	}
 	w.newlineDepth = 0
-	w.mustWrite(p)
+	w.writeRaw(p)
 	return true
 }
 
-func (w *writer) mustWrite(p []byte) {
-	_, err := w.dst.Write(p)
-	if err != nil {
-		panic(err)
+// writeRaw writes p to dst, unless a write has failed, see writer.err.
+func (w *writer) writeRaw(p []byte) {
+	if w.err != nil {
+		return
+	}
+	if _, err := w.dst.Write(p); err != nil {
+		w.err = &FormatError{Err: err}
 	}
 }
@@ -2,12 +2,15 @@ package htmlfmt
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/yosssi/gohtml"
+	"golang.org/x/net/html"
 
 	qt "github.com/frankban/quicktest"
 )
@@ -83,6 +86,219 @@ func TestFormat(t *testing.T) {
 		formatAndCheck(c, 2, "<code>  <div>    Hello     </div>  </code>", "<code>  <div>    Hello     </div>  </code>")
 		formatAndCheck(c, 2, "<!-- comment1 --><!-- comment2 -->", "<!-- comment1 -->\n<!-- comment2 -->")
 		formatAndCheck(c, 2, `<div class="foo" id="bar"></div>`, `<div class="foo" id="bar"></div>`)
+		formatAndCheck(c, 2, "<div><pre><pre> a</pre> </pre><div><div>b</div></div></div>", "<div>\n  <pre><pre> a</pre> </pre>\n  <div>\n    <div>b</div>\n  </div>\n</div>")
+	})
+
+	c.Run("Print width", func(c *qt.C) {
+		opt := WithPrintWidth(40)
+		formatAndCheck(c, 2, "<div>abcdefghijabcdefghijabcdefghi</div>", "<div>abcdefghijabcdefghijabcdefghi</div>", opt)
+		formatAndCheck(c, 2, "<div>abcdefghijabcdefghijabcdefghij</div>", "<div>\n  abcdefghijabcdefghijabcdefghij\n</div>", opt)
+		// Wide characters count as two columns.
+		formatAndCheck(c, 2, "<div>日本語のテキストです</div>", "<div>日本語のテキストです</div>", opt)
+		formatAndCheck(c, 2, "<div>日本語のテキストです日本語のテキスト</div>", "<div>\n  日本語のテキストです日本語のテキスト\n</div>", opt)
+		// The indentation counts.
+		formatAndCheck(c, 2, "<div><div><div><span>Hello world, this is it</span></div></div></div>",
+			"<div>\n  <div>\n    <div>\n      <span>\n        Hello world, this is it\n      </span>\n    </div>\n  </div>\n</div>", opt)
+		formatAndCheck(c, 2, fmt.Sprintf("<div>%s</div>", longTextWithoutNewlines), fmt.Sprintf("<div>%s</div>", longTextWithoutNewlines), WithPrintWidth(80))
+	})
+
+	c.Run("Wrap attributes", func(c *qt.C) {
+		opt := WithPrintWidth(40)
+		formatAndCheck(c, 2, `<div class="foo" id="bar"></div>`, `<div class="foo" id="bar"></div>`, opt)
+		formatAndCheck(c, 2, `<div><div class="foo bar baz qux quux corge" id="main" data-x=1 hidden><span>Hi</span></div></div>`,
+			"<div>\n  <div\n    class=\"foo bar baz qux quux corge\"\n    id=\"main\"\n    data-x=1\n    hidden\n  >\n    <span>Hi</span>\n  </div>\n</div>", opt)
+		formatAndCheck(c, 2, `<div class="foo bar baz qux quux corge" id="main"><span>Hi</span></div>`,
+			"<div\n  class=\"foo bar baz qux quux corge\"\n  id=\"main\">\n  <span>Hi</span>\n</div>", opt, WithBracketSameLine(true))
+		formatAndCheck(c, 2, `<input type="text" name="username" placeholder="Your name"/>`,
+			"<input\n  type=\"text\"\n  name=\"username\"\n  placeholder=\"Your name\"\n/>", opt)
+		formatAndCheck(c, 2, `<img src="/images/a-very-long-file-name.png" alt='A "quoted" alt'>`,
+			"<img\n  src=\"/images/a-very-long-file-name.png\"\n  alt='A \"quoted\" alt'\n>", opt)
+	})
+
+	c.Run("Render tags", func(c *qt.C) {
+		formatAndCheck(c, 2, `<DIV Class='foo' data-x=1 hidden><BR/><img src="a.png" /></DIV>`, "<DIV Class='foo' data-x=1 hidden>\n  <BR/><img src=\"a.png\" />\n</DIV>")
+		formatAndCheck(c, 2, "<div\n  class=\"foo\"\n\tid=\"bar\"></div>", `<div class="foo" id="bar"></div>`)
+	})
+
+	c.Run("Attribute order", func(c *qt.C) {
+		formatAndCheck(c, 2, `<div title="t" class="foo" id="bar"></div>`, `<div title="t" class="foo" id="bar"></div>`)
+		formatAndCheck(c, 2, `<div title="t" data-b=2 class="foo" data-a='1' id="bar"></div>`, `<div id="bar" class="foo" data-a='1' data-b=2 title="t"></div>`,
+			WithAttributeOrder("id", "name", "class", "data-*", "aria-*"))
+	})
+
+	c.Run("Attribute quotes", func(c *qt.C) {
+		src := `<input class='a' value=1 title='Say "Hi"' alt="It's" data-x='&amp;"&#39;' disabled>`
+		formatAndCheck(c, 2, src, src)
+		formatAndCheck(c, 2, src, `<input class="a" value="1" title='Say "Hi"' alt="It's" data-x="&amp;&quot;&#39;" disabled>`, WithQuoteStyle(QuoteDouble))
+		formatAndCheck(c, 2, src, `<input class='a' value='1' title='Say "Hi"' alt="It's" data-x='&amp;"&#39;' disabled>`, WithQuoteStyle(QuoteSingle))
+	})
+
+	c.Run("Boolean attributes", func(c *qt.C) {
+		src := `<input disabled checked="" readonly="readonly" required="yes" class="">`
+		formatAndCheck(c, 2, src, src)
+		formatAndCheck(c, 2, src, `<input disabled checked readonly required="yes" class="">`, WithBooleanAttributeStyle(BooleanAttributeMinimized))
+		formatAndCheck(c, 2, src, `<input disabled="" checked="" readonly="" required="yes" class="">`, WithBooleanAttributeStyle(BooleanAttributeEmpty))
+		formatAndCheck(c, 2, src, `<input disabled='disabled' checked='checked' readonly='readonly' required='yes' class=''>`,
+			WithBooleanAttributeStyle(BooleanAttributeName), WithQuoteStyle(QuoteSingle))
+	})
+
+	c.Run("Element options", func(c *qt.C) {
+		formatAndCheck(c, 2, "<div><p>Click <x-icon>i</x-icon> here</p></div>", "<div>\n  <p>\n    Click <x-icon>i</x-icon> here\n  </p>\n</div>", WithInlineElements("x-icon"))
+		formatAndCheck(c, 2, "<div><b>s1</b><b>s2</b></div>", "<div><b>s1</b><b>s2</b></div>")
+		formatAndCheck(c, 2, "<div><b>s1</b><b>s2</b></div>", "<div>\n  <b>s1</b>\n  <b>s2</b>\n</div>", WithInlineElements("-b"))
+		formatAndCheck(c, 2, "<ul><li>a</li></ul>", "<ul>\n  <li>a</li>\n</ul>", WithAlwaysBreakElements("ul"))
+		formatAndCheck(c, 2, "<body>Hi</body>", "<body>\n  Hi\n</body>")
+		formatAndCheck(c, 2, "<body>Hi</body>", "<body>Hi</body>", WithAlwaysBreakElements("-body"))
+		formatAndCheck(c, 2, "<x-pre>  <div>    Hello     </div>  </x-pre>", "<x-pre>  <div>    Hello     </div>  </x-pre>", WithPreformattedElements("x-pre"))
+		formatAndCheck(c, 2, "<p>AAA<x-br>BBB</p>", "<p>\n  AAA\n  <x-br>\n  BBB\n</p>", WithVoidElements("x-br"))
+	})
+
+	c.Run("Phrasing content", func(c *qt.C) {
+		formatAndCheck(c, 2, `<div><p>Some text <img src="x.png"> more <mark>hi</mark> and <button>Go</button> now.</p><label>Name <input name="n"/> x</label></div>`,
+			"<div>\n  <p>\n    Some text <img src=\"x.png\"> more <mark>hi</mark> and <button>Go</button> now.\n  </p>\n  <label>\n    Name <input name=\"n\"/> x\n  </label>\n</div>")
+		formatAndCheck(c, 2, "<p><ruby>漢<rp>(</rp><rt>kan</rt><rp>)</rp></ruby></p>", "<p><ruby>漢<rp>(</rp><rt>kan</rt><rp>)</rp></ruby></p>", WithPrintWidth(80))
+	})
+
+	c.Run("Whitespace sensitivity", func(c *qt.C) {
+		formatAndCheck(c, 2, "<div><span>a</span> <span>b</span></div>", "<div>\n  <span>a</span> <span>b</span>\n</div>")
+		formatAndCheck(c, 2, "<div><span>a</span>\n<span>b</span></div>", "<div>\n  <span>a</span>\n  <span>b</span>\n</div>")
+		formatAndCheck(c, 2, "<p>x<span> a</span>b</p>", "<p>x<span> a</span>b</p>")
+		formatAndCheck(c, 2, "<div><span>xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx</span>y</div>", "<div>\n  <span>xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx</span>y\n</div>")
+		formatAndCheck(c, 2, "<div><b>xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx </b>y</div>", "<div>\n  <b>\n    xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx\n  </b>y\n</div>")
+		formatAndCheck(c, 2, "<p>a <b> b</b></p>", "<p>a <b>b</b></p>")
+		formatAndCheck(c, 2, "<p>a <!-- c --> b</p>", "<p>a <!-- c -->b</p>")
+
+		ignore := WithWhitespaceSensitivity(WhitespaceIgnore)
+		formatAndCheck(c, 2, "<div><span>a</span> <span>b</span></div>", "<div>\n  <span>a</span><span>b</span>\n</div>", ignore)
+		formatAndCheck(c, 2, "<p>x<span> a</span>b</p>", "<p>x<span>a</span>b</p>", ignore)
+
+		strict := WithWhitespaceSensitivity(WhitespaceStrict)
+		formatAndCheck(c, 2, "<div><div>Hello</div><div><span>s1</span> <span>s2</span></div></div>", "<div>\n  <div>Hello</div><div><span>s1</span> <span>s2</span></div>\n</div>", strict)
+		formatAndCheck(c, 2, "<div>\n<div>Hello</div> <div>\n<span>s1</span> <span>s2</span></div></div>", "<div>\n  <div>Hello</div>\n  <div>\n    <span>s1</span> <span>s2</span>\n  </div>\n</div>", strict)
+	})
+
+	c.Run("Ignore directives", func(c *qt.C) {
+		formatAndCheck(c, 2, "<div>\n<!-- htmlfmt-ignore -->\n<table><tr><td>a</td>   <td>b</td></tr></table>\n<p>Hello</p></div>",
+			"<div>\n  <!-- htmlfmt-ignore -->\n  <table><tr><td>a</td>   <td>b</td></tr></table>\n  <p>Hello</p>\n</div>")
+		formatAndCheck(c, 2, "<div><!-- htmlfmt-ignore --><div> <div>a</div> </div><div><div>b</div></div></div>",
+			"<div>\n  <!-- htmlfmt-ignore --><div> <div>a</div> </div>\n  <div>\n    <div>b</div>\n  </div>\n</div>")
+		formatAndCheck(c, 2, "<div><!-- htmlfmt-ignore --><p>a</p> <p>b</p><!-- htmlfmt-ignore --><b>c</b><span>d</span></div>",
+			"<div>\n  <!-- htmlfmt-ignore --><p>a</p>\n  <p>b</p>\n  <!-- htmlfmt-ignore --><b>c</b><span>d</span>\n</div>")
+		formatAndCheck(c, 2, "<div>\n<!-- htmlfmt-ignore-start -->\n<p>  a  </p>\n    <p>b</p>\n<!-- htmlfmt-ignore-end -->\n<div><div>x</div></div></div>",
+			"<div>\n  <!-- htmlfmt-ignore-start -->\n<p>  a  </p>\n    <p>b</p>\n<!-- htmlfmt-ignore-end -->\n  <div>\n    <div>x</div>\n  </div>\n</div>")
+		formatAndCheck(c, 2, "<div><div>a</div>\n<!-- htmlfmt:off --></div>", "<div><div>a</div>\n<!-- htmlfmt:off --></div>")
+		// Not a directive.
+		formatAndCheck(c, 2, "<div><!-- htmlfmt-ignore-this --><div><div>a</div></div></div>",
+			"<div>\n  <!-- htmlfmt-ignore-this --><div>\n    <div>a</div>\n  </div>\n</div>")
+	})
+
+	c.Run("Go templates", func(c *qt.C) {
+		opt := WithGoTemplates()
+		formatAndCheck(c, 2, `<div class="{{ .Class }}" {{ if .X }}hidden{{ end }}><div><div>{{ printf "}}" }}</div></div></div>`,
+			"<div class=\"{{ .Class }}\" {{ if .X }}hidden{{ end }}>\n  <div>\n    <div>{{ printf \"}}\" }}</div>\n  </div>\n</div>", opt)
+		formatAndCheck(c, 2, "<div><p>{{- .A\n  | b -}}</p><p>x</p></div>", "<div>\n  <p>{{- .A\n  | b -}}</p>\n  <p>x</p>\n</div>", opt)
+		formatAndCheck(c, 2, `<input value="{{ "<>" }}" {{ if .C }}checked{{ end }}>`, `<input value="{{ "<>" }}" {{ if .C }}checked{{ end }}>`, opt)
+		formatAndCheck(c, 2, "<div><p>[[ .A ]]</p><p>x</p></div>", "<div>\n  <p>[[ .A ]]</p>\n  <p>x</p>\n</div>", WithTemplateDelims("[[", "]]"))
+		// The runes used for placeholders, e.g. in icon fonts.
+		formatAndCheck(c, 2, "<div><p><i>\uE000</i> a\uE001b {{ \"\uE000\" }}</p></div>",
+			"<div>\n  <p>\n    <i>\uE000</i> a\uE001b {{ \"\uE000\" }}\n  </p>\n</div>", opt)
+		formatAndCheck(c, 2, "<i title=\"\uE001\"></i>", "<i title=\"\uE001\"></i>", opt, WithQuoteStyle(QuoteSingle))
+		// Attributes are not reordered or requoted across actions.
+		src := `<div {{ if .X }}id="a"{{ else }}class="b"{{ end }} title="t"></div>`
+		formatAndCheck(c, 2, src, `<div {{ if .X }}id="a" {{ else }}class="b" {{ end }} title="t"></div>`, opt, WithAttributeOrder("title"), WithQuoteStyle(QuoteSingle))
+		formatAndCheck(c, 2, `<p {{ if .X }}class=a{{ end }}></p>`, `<p {{ if .X }}class=a{{ end }}></p>`, opt, WithQuoteStyle(QuoteDouble))
+		formatAndCheck(c, 2, `<a title='{{ "x" }}' href=y class=z></a>`, `<a class="z" href="y" title='{{ "x" }}'></a>`, opt, WithAttributeOrder("class", "href"), WithQuoteStyle(QuoteDouble))
+	})
+
+	c.Run("Go template blocks", func(c *qt.C) {
+		opt := WithGoTemplates()
+		formatAndCheck(c, 2, "<ul>\n{{ range .Pages }}\n<li>{{ .Title }}</li>\n{{ else }}\n<li>None</li>\n{{ end }}\n</ul>",
+			"<ul>\n  {{ range .Pages }}\n    <li>{{ .Title }}</li>\n  {{ else }}\n    <li>None</li>\n  {{ end }}\n</ul>", opt)
+		formatAndCheck(c, 2, "{{ define \"main\" }}{{- if .X -}}<div><div>a</div></div>{{ else if .Y }}<p>b</p>{{- end -}}{{ end }}",
+			"{{ define \"main\" }}\n  {{- if .X -}}\n    <div>\n      <div>a</div>\n    </div>\n  {{ else if .Y }}\n    <p>b</p>\n  {{- end -}}\n{{ end }}", opt)
+		// Inline.
+		formatAndCheck(c, 2, "<p>Say {{ if .X }}hi{{ end }}</p>", "<p>Say {{ if .X }}hi{{ end }}</p>", opt, WithPrintWidth(80))
+		// Not matching the HTML structure.
+		formatAndCheck(c, 2, "<div>{{ if .X }}<div class=\"a\">{{ else }}<div class=\"b\">{{ end }}<p>x</p></div></div>",
+			"<div>\n  {{ if .X }}<div class=\"a\">{{ else }}<div class=\"b\">{{ end }}\n  <p>x</p></div>\n</div>", opt)
+		// Not in script.
+		formatAndCheck(c, 2, "<script>{{ if .X }}var x;{{ end }}</script>", "<script>\n  {{ if .X }}var x;{{ end }}\n</script>", opt)
+	})
+
+	c.Run("Template syntaxes", func(c *qt.C) {
+		formatAndCheck(c, 2, "<ul>{# a #}{% for u in users %}<li>{{ u.name }}</li>{% else %}<li>None</li>{%- endfor %}</ul>",
+			"<ul>\n  {# a #}{% for u in users %}\n    <li>{{ u.name }}</li>\n  {% else %}\n    <li>None</li>\n  {%- endfor %}\n</ul>", WithTemplateSyntax(JinjaSyntax))
+		formatAndCheck(c, 2, "<ul>{{#each items}}<li>{{{this}}}</li>{{/each}}</ul>",
+			"<ul>\n  {{#each items}}\n    <li>{{{this}}}</li>\n  {{/each}}\n</ul>", WithTemplateSyntax(HandlebarsSyntax))
+		formatAndCheck(c, 2, "<% if a %><div><div>x</div></div><% elsif b %><p><%= b %></p><% end %>",
+			"<% if a %>\n  <div>\n    <div>x</div>\n  </div>\n<% elsif b %>\n  <p><%= b %></p>\n<% end %>", WithTemplateSyntax(ERBSyntax))
+	})
+
+	c.Run("Opaque patterns", func(c *qt.C) {
+		php := WithOpaquePattern(regexp.MustCompile(`(?s)<\?php.*?\?>`), OpaqueBlock)
+		formatAndCheck(c, 2, "<div><?php if ($a < 2): ?><p>Hello</p><?php endif; ?></div>",
+			"<div>\n  <?php if ($a < 2): ?>\n  <p>Hello</p>\n  <?php endif; ?>\n</div>", php)
+		formatAndCheck(c, 2, "<p><?php a ?></p>", "<p>\n  <?php a ?>\n</p>", php)
+		formatAndCheck(c, 2, `<p title="<?php a ?>">b <?php c ?></p>`, "<p title=\"<?php a ?>\">\n  b\n  <?php c ?>\n</p>", php)
+		formatAndCheck(c, 2, "<p>Hello @name <b>x</b></p>", "<p>Hello @name <b>x</b></p>", php,
+			WithOpaquePattern(regexp.MustCompile(`@\w+`), OpaqueInline), WithPrintWidth(80))
+		// Template tags are not looked for in opaque regions.
+		formatAndCheck(c, 2, "<div>{{ if .X }}<p><?php \"{{ if\" ?></p>{{ end }}</div>",
+			"<div>\n  {{ if .X }}\n    <p>\n      <?php \"{{ if\" ?>\n    </p>\n  {{ end }}\n</div>", php, WithGoTemplates())
+	})
+
+	c.Run("Go template validation", func(c *qt.C) {
+		opts := []Option{WithGoTemplates(), WithTemplateValidation(true)}
+		formatAndCheck(c, 2, "<ul>{{ range .Pages }}<li><a href=\"{{ .Permalink }}\">{{ .Title | upper }}</a></li>{{ end }}</ul>",
+			"<ul>\n  {{ range .Pages }}\n    <li>\n      <a href=\"{{ .Permalink }}\">\n        {{ .Title | upper }}\n      </a>\n    </li>\n  {{ end }}\n</ul>", opts...)
+		// Not a valid template.
+		formatAndCheck(c, 1, "<div>{{ if .X }}</div>", true, opts...)
+		// Only for Go templates.
+		formatAndCheck(c, 1, "<div>{{ .X }}</div>", true, WithTemplateValidation(true))
+		formatAndCheck(c, 1, "<div>{{ x }}</div>", true, WithTemplateSyntax(JinjaSyntax), WithTemplateValidation(true))
+		formatAndCheck(c, 2, "<div>{{ .X }}</div>", "<div>{{ .X }}</div>", WithTemplateValidation(false))
+		// A line break added between two actions is written when executed.
+		formatAndCheck(c, 1, "<div>{{ if .X }}{{ .Y }}<p>x</p>{{ end }}</div>", true, opts...)
+		formatAndCheck(c, 2, "<div>{{ if .X }}{{ .Y }}<p>x</p>{{ end }}</div>",
+			"<div>\n  {{ if .X }}\n    {{ .Y }}<p>x</p>\n  {{ end }}\n</div>", WithGoTemplates())
+		formatAndCheck(c, 2, "<div>{{ if .X -}} {{ .Y }}<p>x</p>{{ end }}</div>",
+			"<div>\n  {{ if .X -}}\n    {{ .Y }}<p>x</p>\n  {{ end }}\n</div>", opts...)
+		// A text formatter changing the template.
+		formatAndCheck(c, 1, "<script>var x = {{ .X }};</script>", true, append(opts, WithTextFormatters(func(tag Tag) TextFormatter {
+			return func(text []byte, depth int) []byte {
+				return bytes.ToUpper(text)
+			}
+		}))...)
+	})
+
+	c.Run("CSS", func(c *qt.C) {
+		opt := WithCSSFormatter()
+		formatAndCheck(c, 2, "<head><style>\nbody{margin:0}\n  a:hover{color:red}</style></head>",
+			"<head>\n  <style>\n    body {\n      margin: 0\n    }\n    a:hover {\n      color: red\n    }\n  </style>\n</head>", opt)
+		formatAndCheck(c, 2, `<div style="color:red;margin : 0">x</div>`, `<div style="color: red; margin: 0">x</div>`, opt, WithPrintWidth(80))
+		formatAndCheck(c, 2, `<style type="text/less">a{b:c}</style>`, `<style type="text/less">a{b:c}</style>`, opt)
+		formatAndCheck(c, 2, `<div style="color:{{ .C }};margin:0">x</div>`, `<div style="color:{{ .C }};margin:0">x</div>`, opt, WithGoTemplates(), WithPrintWidth(80))
+		// Not the text after the style element.
+		formatAndCheck(c, 2, "<div><style>a{b:c}</style>x{y}</div>", "<div>\n  <style>\n    a {\n      b: c\n    }\n  </style>\n  x{y}\n</div>", opt)
+	})
+
+	c.Run("JavaScript", func(c *qt.C) {
+		opt := WithJSFormatter()
+		formatAndCheck(c, 2, "<div><script>\n    function f() {\n  return `a\n    b`;\n        }\n</script></div>",
+			"<div>\n  <script>\n    function f() {\n      return `a\n    b`;\n    }\n  </script>\n</div>", opt)
+		formatAndCheck(c, 2, "<script type=\"text/template\"><div>\n  </div></script>", "<script type=\"text/template\">\n  <div>\n  </div>\n</script>", opt)
+	})
+
+	c.Run("JSON", func(c *qt.C) {
+		opt := WithJSONFormatter()
+		formatAndCheck(c, 2, `<head><script type="application/ld+json">{"@context":"https://schema.org","@type":"Person"}</script></head>`,
+			"<head>\n  <script type=\"application/ld+json\">\n    {\n      \"@context\": \"https://schema.org\",\n      \"@type\": \"Person\"\n    }\n  </script>\n</head>", opt)
+		formatAndCheck(c, 2, `<script type="importmap">{"imports":{}}</script>`, "<script type=\"importmap\">\n  {\n    \"imports\": {}\n  }\n</script>", opt)
+
+		var warnings []error
+		formatAndCheck(c, 1, `<script type="application/json">{"a":</script>`, `<script type="application/json">{"a":</script>`,
+			opt, WithWarningHandler(func(err error) { warnings = append(warnings, err) }))
+		c.Assert(warnings, qt.HasLen, 1)
 	})
 
 	c.Run("Custom text formatter", func(c *qt.C) {
@@ -99,6 +315,80 @@ func TestFormat(t *testing.T) {
 					return bytes.ToUpper(text)
 				}
 			}))
+
+		var r TextFormatterRegistry
+		r.Register("script", "text/javascript", TextFormatter(func(text []byte, depth int) []byte {
+			return bytes.ToUpper(text)
+		}))
+		formatAndCheck(c, 2, `<script>hello</script><script type="application/javascript">hello</script><script type="module">hello</script>`,
+			"<script>HELLO</script>\n<script type=\"application/javascript\">HELLO</script>\n<script type=\"module\">hello</script>",
+			WithContextTextFormatters(r.Lookup))
+	})
+
+	c.Run("Context text formatter", func(c *qt.C) {
+		var contexts []TextContext
+		opt := WithContextTextFormatters(func(tag Tag) ContextTextFormatter {
+			if tag.Name != "script" {
+				return nil
+			}
+			return TextFormatterFunc(func(ctx TextContext, text []byte) ([]byte, error) {
+				contexts = append(contexts, ctx)
+				if bytes.Contains(text, []byte("fail")) {
+					return nil, errors.New("failed")
+				}
+				return bytes.ToUpper(text), nil
+			})
+		})
+		formatAndCheck(c, 1, "<html><body><div><script>a</script></div></body></html>",
+			"<html>\n\t<body>\n\t\t<div>\n\t\t\t<script>A</script>\n\t\t</div>\n\t</body>\n</html>", opt, WithTab("\t"), WithPrintWidth(80))
+		c.Assert(contexts, qt.HasLen, 1)
+		ctx := contexts[0]
+		c.Assert(ctx.Tag.Name, qt.Equals, "script")
+		c.Assert(ctx.Ancestors, qt.HasLen, 3)
+		c.Assert(ctx.Ancestors[0].Name+ctx.Ancestors[2].Name, qt.Equals, "htmldiv")
+		c.Assert(ctx.Depth, qt.Equals, 3)
+		c.Assert(ctx.Tab, qt.Equals, "\t")
+		c.Assert(ctx.Newline, qt.Equals, "\n")
+		c.Assert(ctx.PrintWidth, qt.Equals, 80)
+		c.Assert(ctx.Offset, qt.Equals, 25)
+
+		// Closed elements are not ancestors.
+		contexts = nil
+		formatAndCheck(c, 1, "<div><p>a</p><br><script>a</script></div>", false, opt)
+		c.Assert(contexts, qt.HasLen, 1)
+		c.Assert(contexts[0].Ancestors, qt.HasLen, 1)
+
+		var buf bytes.Buffer
+		err := New(opt).Format(&buf, strings.NewReader("<div>\n  <script>\nfail</script></div>"))
+		c.Assert(err, qt.ErrorMatches, "2:11: formatting <script>: failed")
+		// Nothing is written on errors.
+		c.Assert(buf.Len(), qt.Equals, 0)
+		// The offset is in the source, not the source with placeholders.
+		contexts = nil
+		err = New(opt, WithGoTemplates()).Format(ioutil.Discard, strings.NewReader("{{ if .X }}\n{{ .Y }}<script>fail</script>{{ end }}"))
+		c.Assert(err, qt.ErrorMatches, "2:17: formatting <script>: failed")
+		c.Assert(contexts[0].Offset, qt.Equals, 28)
+	})
+
+	c.Run("Implied end tags", func(c *qt.C) {
+		formatAndCheck(c, 2, "<ul><li>a<li>b</ul>", "<ul>\n  <li>a\n  <li>b\n</ul>")
+		formatAndCheck(c, 2, "<ul>\n  <li>One\n  <li>Two\n</ul>\n<p>Three", "<ul>\n  <li>One\n  <li>Two\n</ul>\n<p>Three")
+		formatAndCheck(c, 2, "<table><tr><td>a<td>b<tr><td>c<td>d</table>",
+			"<table>\n  <tr>\n    <td>a\n    <td>b\n  <tr>\n    <td>c\n    <td>d\n</table>")
+		formatAndCheck(c, 2, "<dl><dt>A<dd>B<dt>C<dd>D</dl>", "<dl>\n  <dt>A\n  <dd>B\n  <dt>C\n  <dd>D\n</dl>")
+		formatAndCheck(c, 2, "<div><p>a<p>b<div>c</div></div>", "<div>\n  <p>a\n  <p>b\n  <div>c</div>\n</div>")
+		formatAndCheck(c, 2, "<select><option>A<option>B</select>", "<select>\n  <option>A\n  <option>B\n</select>")
+		formatAndCheck(c, 2, "<ul>{{ range .X }}<li>{{ . }}{{ end }}</ul>", "<ul>\n  {{ range .X }}\n    <li>{{ . }}\n  {{ end }}\n</ul>", WithGoTemplates())
+
+		// Written with WithImpliedEndTags.
+		implied := WithImpliedEndTags()
+		formatAndCheck(c, 2, "<ul>\n  <li>One\n  <li>Two\n</ul>\n<p>Three", "<ul>\n  <li>One</li>\n  <li>Two</li>\n</ul>\n<p>Three</p>", implied)
+		formatAndCheck(c, 2, "<table><tr><td>a<td>b</table>", "<table>\n  <tr>\n    <td>a</td>\n    <td>b</td>\n  </tr>\n</table>", implied)
+		formatAndCheck(c, 2, "<div><p>a<div>b</div></div>", "<div>\n  <p>a</p>\n  <div>b</div>\n</div>", implied)
+		formatAndCheck(c, 2, "<html><head><title>T</title><body><p>a", "<html>\n  <head>\n    <title>T</title>\n  </head>\n  <body>\n    <p>a</p>\n  </body>\n</html>", implied)
+		formatAndCheck(c, 2, "<ul>{{ range .X }}<li>{{ . }}{{ end }}</ul>", "<ul>\n  {{ range .X }}\n    <li>{{ . }}</li>\n  {{ end }}\n</ul>", implied, WithGoTemplates())
+		// Not ended over an element without an end tag.
+		formatAndCheck(c, 2, "<div><p>a<span>b</div>", "<div>\n  <p>a<span>b</div>", implied)
 	})
 
 	c.Run("Text elements", func(c *qt.C) {
@@ -141,6 +431,66 @@ func TestFormatTextBlock(t *testing.T) {
 	c.Assert(f("\n          foo\n          bar", 1), qt.Equals, "\n%foo\n%bar")
 }
 
+// failingWriter fails after n successful writes.
+type failingWriter struct {
+	n      int
+	writes int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > w.n {
+		return 0, errors.New("write failed")
+	}
+	return len(p), nil
+}
+
+func TestFormatWriteError(t *testing.T) {
+	c := qt.New(t)
+
+	src := "<div><p>a</p><p>b</p></div><!-- htmlfmt-ignore --><p> c </p>"
+	p := newParser(strings.NewReader(src), []byte("  "), defaultElements)
+	tokens, err := p.parse()
+	c.Assert(err, qt.IsNil)
+	for n := 0; n < 5; n++ {
+		w := &failingWriter{n: n}
+		err := New().formatTokens(w, tokens, false, []byte(src))
+		c.Assert(err, qt.ErrorMatches, "write failed")
+		var fe *FormatError
+		c.Assert(errors.As(err, &fe), qt.IsTrue)
+		c.Assert(w.writes, qt.Equals, n+1)
+	}
+
+	// Format writes the result once.
+	w := &failingWriter{}
+	err = New().Format(w, strings.NewReader(src))
+	c.Assert(err, qt.ErrorMatches, "write failed")
+	var fe *FormatError
+	c.Assert(errors.As(err, &fe), qt.IsTrue)
+	c.Assert(w.writes, qt.Equals, 1)
+
+	for _, opt := range []Option{WithGoTemplates(), WithTab("  ")} {
+		w := &failingWriter{}
+		err := New(opt).Format(w, strings.NewReader("<!-- htmlfmt:off -->"+src))
+		c.Assert(err, qt.ErrorMatches, "write failed")
+		c.Assert(w.writes, qt.Equals, 1)
+	}
+}
+
+func TestFormatUnhandledToken(t *testing.T) {
+	c := qt.New(t)
+
+	src := []byte("<div>\n<p>a</p></div>")
+	p := newParser(bytes.NewReader(src), []byte("  "), defaultElements)
+	tokens, err := p.parse()
+	c.Assert(err, qt.IsNil)
+	tokens[2].typ = html.TokenType(99)
+
+	err = New().formatTokens(ioutil.Discard, tokens, false, src)
+	c.Assert(errors.Is(err, ErrUnhandledToken), qt.IsTrue)
+	c.Assert(err, qt.ErrorMatches, "2:1: unhandled token")
+}
+
 var benchmarkHTML = `<!DOCTYPE html><html><head><title class="foo">This is a title.</title></head><body><p>Line1<br>` + longTextWithNewlines + `</p><br/></body></html> <!-- aaa -->`
 
 func BenchmarkFormat(b *testing.B) {
@@ -0,0 +1,176 @@
+package htmlfmt
+
+import "golang.org/x/net/html"
+
+// The end tags that may be omitted, by the start tags that imply them,
+// see https://html.spec.whatwg.org/multipage/syntax.html#optional-tags
+// Any of them is also implied by the end of its parent element, e.g. the
+// last </li> by </ul>.
+var impliedEndTags = map[string]elementSet{
+	"head": newElementSet("body"),
+	"body": newElementSet(),
+	"html": newElementSet(),
+
+	"li": newElementSet("li"),
+	"dt": newElementSet("dt", "dd"),
+	"dd": newElementSet("dt", "dd"),
+	"p": newElementSet(
+		"address", "article", "aside", "blockquote", "details", "dialog",
+		"div", "dl", "fieldset", "figcaption", "figure", "footer", "form", "h1",
+		"h2", "h3", "h4", "h5", "h6", "header", "hgroup", "hr", "main", "menu",
+		"nav", "ol", "p", "pre", "search", "section", "table", "ul",
+	),
+	"rt":       newElementSet("rt", "rp"),
+	"rp":       newElementSet("rt", "rp"),
+	"optgroup": newElementSet("optgroup", "hr"),
+	"option":   newElementSet("option", "optgroup", "hr"),
+
+	// The table rows and cells are also implied by the start of the next
+	// row or table section.
+	"colgroup": newElementSet("colgroup", "caption", "thead", "tbody", "tfoot", "tr"),
+	"thead":    newElementSet("tbody", "tfoot"),
+	"tbody":    newElementSet("tbody", "tfoot"),
+	"tfoot":    newElementSet(),
+	"tr":       newElementSet("tr", "tbody", "tfoot"),
+	"td":       newElementSet("td", "th", "tr", "tbody", "tfoot"),
+	"th":       newElementSet("td", "th", "tr", "tbody", "tfoot"),
+}
+
+// WithImpliedEndTags configures the formatter to write the end tags
+// omitted in the source where the HTML parser would end the elements,
+// e.g. <ul><li>a<li>b</ul> as <ul><li>a</li><li>b</li></ul>, including
+// those ended by the end of the source, e.g. </body> and </html>.
+//
+// Without it, the elements are still ended there, but only in the
+// indentation.
+func WithImpliedEndTags() Option {
+	return func(f *Formatter) { f.impliedEndTags = true }
+}
+
+// hasOptionalEndTag reports whether the end tag of the named element may
+// be omitted.
+func hasOptionalEndTag(name string) bool {
+	_, found := impliedEndTags[name]
+	return found
+}
+
+// closeImplied ends the open elements whose end tag is implied by the
+// token of type typ with the given tag name: a start tag, an end tag or a
+// template action continuing or ending a block.
+func (prs *parser) closeImplied(typ html.TokenType, name string) {
+	// The number of elements to end.
+	var n int
+Loop:
+	for i := len(prs.stack) - 1; i >= 0; i-- {
+		open := prs.stack[i]
+		switch typ {
+		case html.StartTagToken:
+			if impliedEndTags[open][name] {
+				// Keep looking, e.g. a <tr> ends both the cell and the row.
+				n = len(prs.stack) - i
+			}
+		case html.EndTagToken:
+			if open == name {
+				n = len(prs.stack) - i - 1
+				break Loop
+			}
+		case templateElseToken, templateEndToken:
+			if open == "" {
+				// The start of the template block.
+				n = len(prs.stack) - i - 1
+				break Loop
+			}
+		default:
+			return
+		}
+		if !hasOptionalEndTag(open) {
+			break
+		}
+	}
+
+	for ; n > 0; n-- {
+		prs.trackImpliedEnd()
+	}
+}
+
+// closeAllImplied ends the elements with an optional end tag open at the
+// end of the source.
+func (prs *parser) closeAllImplied() {
+	for len(prs.stack) > 0 && hasOptionalEndTag(prs.stack[len(prs.stack)-1]) {
+		prs.trackImpliedEnd()
+	}
+}
+
+// trackImpliedEnd tracks an end tag without source for the innermost
+// open element.
+func (prs *parser) trackImpliedEnd() {
+	name := prs.stack[len(prs.stack)-1]
+
+	currType, tag, tagName := prs.currType, prs.tag, prs.tagName
+	prs.currType, prs.tag, prs.tagName = html.EndTagToken, Tag{Name: name}, []byte(name)
+	defer func() {
+		prs.currType, prs.tag, prs.tagName = currType, tag, tagName
+	}()
+
+	// Whitespace before the next tag goes after the end tag, as if written
+	// as e.g. <li>a</li>\n<li>b.
+	var space *token
+	if n := len(prs.tokens); n > 0 && prs.tokens[n-1].text.isWhitespaceOnly && !prs.tokens[n-1].verbatim {
+		space = prs.removeLastToken()
+	}
+
+	if prs.checkTags {
+		prs.checkTag()
+	}
+	prs.trackOpen(html.EndTagToken, nil, -1, false)
+	t := prs.tokens[len(prs.tokens)-1]
+	t.implied = true
+	if prs.impliedEndTags {
+		// Set after tracking, as it is not in the source, see advance.
+		t.raw = []byte("</" + name + ">")
+	}
+	prs.stack = prs.stack[:len(prs.stack)-1]
+
+	if space != nil {
+		space.i, space.depth = prs.counter, prs.depth
+		prs.counter++
+		prs.appendToken(space)
+	}
+}
+
+// removeLastToken removes the last token added and returns it.
+func (prs *parser) removeLastToken() *token {
+	t := prs.tokens[len(prs.tokens)-1]
+	prs.tokens = prs.tokens[:len(prs.tokens)-1]
+	prs.counter--
+	for i := len(prs.tokens) - 1; i >= 0; i-- {
+		if children := prs.tokens[i].children; len(children) > 0 && children[len(children)-1] == t {
+			prs.tokens[i].children = children[:len(children)-1]
+			break
+		}
+	}
+	return t
+}
+
+// trackStack tracks the element started or ended by the current token in
+// the stack of open elements.
+func (prs *parser) trackStack(typ html.TokenType, name string) {
+	switch typ {
+	case html.StartTagToken:
+		if !prs.elements.isVoid(name) {
+			prs.stack = append(prs.stack, name)
+		}
+	case templateStartToken:
+		prs.stack = append(prs.stack, "")
+	case html.EndTagToken, templateEndToken:
+		if typ == templateEndToken {
+			name = ""
+		}
+		for i := len(prs.stack) - 1; i >= 0; i-- {
+			if prs.stack[i] == name {
+				prs.stack = prs.stack[:i]
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package htmlfmt
+
+import "bytes"
+
+// WithJSFormatter configures the formatter to reindent the content of
+// <script> elements containing JavaScript, see JSFormatter.
+// Text formatters set with WithTextFormatters take precedence.
+func WithJSFormatter() Option {
+	return func(f *Formatter) { f.formatJS = true }
+}
+
+// JSFormatter returns a TextFormatter for scripts, indenting with tab.
+//
+// Each line is indented one level for every line with brackets open at
+// its start, e.g. the body of a function one level deeper than the
+// function. The script is otherwise unchanged: lines starting inside a
+// string or template literal are written as is, as are literals, regular
+// expressions and comments.
+// Runs of blank lines are written as one.
+// Scripts with unbalanced brackets are returned unchanged.
+func JSFormatter(tab string) TextFormatter {
+	tabStr := []byte(tab)
+	return func(text []byte, depth int) []byte {
+		lines, ok := parseJS(text)
+		if !ok {
+			return text
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+
+		var b bytes.Buffer
+		b.WriteByte('\n')
+		for _, l := range lines {
+			if !l.verbatim && len(l.text) > 0 {
+				b.Write(bytes.Repeat(tabStr, depth+1+l.level))
+			}
+			b.Write(l.text)
+			b.WriteByte('\n')
+		}
+		b.Write(bytes.Repeat(tabStr, depth))
+		return b.Bytes()
+	}
+}
+
+// jsLine is a line of a script with the leading whitespace removed, at
+// the given bracket level.
+type jsLine struct {
+	level    int
+	text     []byte
+	verbatim bool // Starts inside a literal, written as is.
+}
+
+type jsBracket struct {
+	c    byte
+	line int // The line the bracket is on.
+}
+
+// jsKeywords are the keywords after which a slash starts a regular
+// expression, not a division.
+var jsKeywords = map[string]bool{
+	"await": true, "case": true, "delete": true, "do": true, "else": true,
+	"in": true, "instanceof": true, "new": true, "of": true, "return": true,
+	"throw": true, "typeof": true, "void": true, "yield": true,
+}
+
+func isJSIdentifier(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// parseJS splits src into lines and finds the bracket level of each.
+// It returns false if the brackets in src are unbalanced.
+func parseJS(src []byte) ([]jsLine, bool) {
+	var (
+		lines []jsLine
+
+		// The open brackets, with ` for template literals and $ for the
+		// expressions in them.
+		stack []jsBracket
+		line  int // The line of the brackets opened, see depth.
+
+		// The last token, for telling regular expressions from division.
+		prev     byte
+		prevWord string
+
+		lineStart    = 0
+		lineVerbatim bool
+		lineComment  = -1 // The level of the comment the line starts in, if any.
+		level        = -1 // The level of the line, once known.
+		commentLevel int
+	)
+
+	// depth returns the level inside the open brackets, one per line with
+	// brackets opened, with the last n closed. The line of the brackets
+	// closed is not counted, e.g. for the "}, {" in
+	//
+	//	f({
+	//	  a: 1,
+	//	}, {
+	//	  b: 2,
+	//	})
+	depth := func(n int) int {
+		d, prevLine := 0, -1
+		if n > 0 {
+			prevLine = stack[len(stack)-n].line
+		}
+		for _, b := range stack[:len(stack)-n] {
+			if b.c != '`' && b.line != prevLine {
+				d++
+				prevLine = b.line
+			}
+		}
+		return d
+	}
+	top := func() byte {
+		if len(stack) == 0 {
+			return 0
+		}
+		return stack[len(stack)-1].c
+	}
+
+	endLine := func(i int, verbatim bool, comment int) {
+		l := jsLine{level: level, text: src[lineStart:i], verbatim: lineVerbatim}
+		if !l.verbatim {
+			if !verbatim {
+				l.text = bytes.TrimRight(l.text, " \t\r\f")
+			}
+			l.text = bytes.TrimLeft(l.text, " \t\r\f")
+			if lineComment != -1 && len(l.text) > 0 && l.text[0] == '*' {
+				// E.g. the " * @param" lines of a JSDoc comment.
+				l.level = lineComment
+				l.text = append([]byte(" "), l.text...)
+			} else if lineComment != -1 {
+				l.verbatim = true
+				l.text = src[lineStart:i]
+			}
+		}
+
+		if l.verbatim || len(l.text) > 0 || len(lines) > 0 && len(lines[len(lines)-1].text) > 0 {
+			lines = append(lines, l)
+		}
+		lineStart, lineVerbatim, lineComment, level = i+1, verbatim, comment, -1
+		line = lineStart
+	}
+
+	// setLevel sets the level of the line at its first token, at i.
+	setLevel := func(i int) {
+		if level != -1 {
+			return
+		}
+		var closed int
+		for ; i < len(src) && bytes.IndexByte([]byte(")]} \t"), src[i]) != -1; i++ {
+			if src[i] != ' ' && src[i] != '\t' && closed < len(stack) && top() != '`' {
+				closed++
+			}
+		}
+		level = depth(closed)
+		if closed > 0 {
+			// The brackets opened continue the line closed.
+			line = stack[len(stack)-closed].line
+		}
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		if top() == '`' {
+			// In a template literal.
+			switch {
+			case c == '\\':
+				i += 2
+			case c == '`':
+				stack = stack[:len(stack)-1]
+				prev, prevWord = c, ""
+				i++
+			case c == '$' && i+1 < len(src) && src[i+1] == '{':
+				stack = append(stack, jsBracket{'$', line})
+				prev, prevWord = '{', ""
+				i += 2
+			case c == '\n':
+				endLine(i, true, -1)
+				i++
+			default:
+				i++
+			}
+			continue
+		}
+
+		switch {
+		case c == '\n':
+			endLine(i, false, -1)
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+			continue
+		}
+
+		setLevel(i)
+
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			commentLevel = depth(0)
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end == -1 {
+				end = len(src)
+			} else {
+				end += i + 4
+			}
+			for i < end {
+				if src[i] == '\n' {
+					endLine(i, false, commentLevel)
+				}
+				i++
+			}
+		case c == '\'' || c == '"':
+			for i++; i < len(src) && src[i] != c && src[i] != '\n'; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					if src[i] == '\n' {
+						// A line continuation.
+						endLine(i, true, -1)
+					}
+				}
+			}
+			if i < len(src) && src[i] == c {
+				i++
+			}
+			prev, prevWord = c, ""
+		case c == '`':
+			stack = append(stack, jsBracket{'`', line})
+			i++
+		case c == '/' && (prev == 0 || bytes.IndexByte([]byte("(,=:[!&|?{};+-*%<>~^"), prev) != -1 || jsKeywords[prevWord]):
+			// A regular expression, unless it ends at the end of the line.
+			j, class := i+1, false
+		Regexp:
+			for ; j < len(src); j++ {
+				switch src[j] {
+				case '\\':
+					j++
+				case '[':
+					class = true
+				case ']':
+					class = false
+				case '/':
+					if !class {
+						break Regexp
+					}
+				case '\n':
+					j = len(src)
+				}
+			}
+			if j >= len(src) {
+				prev, prevWord = c, ""
+				i++
+				break
+			}
+			for j++; j < len(src) && isJSIdentifier(src[j]); j++ {
+			}
+			// A value, as after a closing parenthesis.
+			prev, prevWord = ')', ""
+			i = j
+		case c == '{' || c == '(' || c == '[':
+			stack = append(stack, jsBracket{c, line})
+			prev, prevWord = c, ""
+			i++
+		case c == '}' || c == ')' || c == ']':
+			if len(stack) == 0 {
+				return nil, false
+			}
+			stack = stack[:len(stack)-1]
+			prev, prevWord = c, ""
+			i++
+		case isJSIdentifier(c):
+			j := i
+			for j < len(src) && isJSIdentifier(src[j]) {
+				j++
+			}
+			prev, prevWord = src[j-1], string(src[i:j])
+			i = j
+		default:
+			prev, prevWord = c, ""
+			i++
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, false
+	}
+	endLine(len(src), false, -1)
+
+	// Remove trailing blank lines.
+	for len(lines) > 0 && !lines[len(lines)-1].verbatim && len(lines[len(lines)-1].text) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines, true
+}
@@ -0,0 +1,39 @@
+package htmlfmt
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestJSFormatter(t *testing.T) {
+	c := qt.New(t)
+
+	format := JSFormatter("  ")
+
+	for _, test := range []struct {
+		src    string
+		depth  int
+		expect string
+	}{
+		{"var a;", 0, "\n  var a;\n"},
+		{"\n\n    if (a) {\nb();\n      }\n\n", 1, "\n    if (a) {\n      b();\n    }\n  "},
+		{"f({\na: [\n1,\n]\n}, {\nb: 2 })", 0, "\n  f({\n    a: [\n      1,\n    ]\n  }, {\n    b: 2 })\n"},
+		{"if (a) {\n} else {\nb()\n}", 0, "\n  if (a) {\n  } else {\n    b()\n  }\n"},
+		{"a();\n\n\n\nb();", 0, "\n  a();\n\n  b();\n"},
+		// Literals.
+		{"{\nx = `{\n  ${ y }\n\n    }`;\n}", 0, "\n  {\n    x = `{\n  ${ y }\n\n    }`;\n  }\n"},
+		{"{\nx = \"{\\\n   }\";\n}", 0, "\n  {\n    x = \"{\\\n   }\";\n  }\n"},
+		{"{\nx = '}' + \"}\";\n}", 0, "\n  {\n    x = '}' + \"}\";\n  }\n"},
+		{"{\nx = /[}]\\//g.test(y) / 2;\n}", 0, "\n  {\n    x = /[}]\\//g.test(y) / 2;\n  }\n"},
+		{"{\nreturn /}/;\n}", 0, "\n  {\n    return /}/;\n  }\n"},
+		// Comments.
+		{"{\n// }\n/* }\n      * a\n   b */\n}", 0, "\n  {\n    // }\n    /* }\n     * a\n   b */\n  }\n"},
+		{"", 0, ""},
+		// Unbalanced.
+		{"if (a) {", 0, "if (a) {"},
+		{"}", 0, "}"},
+	} {
+		c.Assert(string(format([]byte(test.src), test.depth)), qt.Equals, test.expect, qt.Commentf(test.src))
+	}
+}
@@ -0,0 +1,55 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// WithJSONFormatter configures the formatter to format the content of
+// <script> elements containing JSON, e.g. <script type="application/ld+json">
+// or <script type="importmap">, see JSONFormatter.
+// Invalid JSON is left as is and reported to the warning handler, see
+// WithWarningHandler.
+// Text formatters set with WithTextFormatters take precedence.
+func WithJSONFormatter() Option {
+	return func(f *Formatter) { f.formatJSON = true }
+}
+
+// JSONFormatter returns a TextFormatter for JSON, indenting with tab.
+// Invalid JSON is returned unchanged.
+func JSONFormatter(tab string) TextFormatter {
+	format := jsonFormatter(nil)
+	return func(text []byte, depth int) []byte {
+		b, _ := format(TextContext{Depth: depth, Tab: tab, Newline: "\n"}, text)
+		return b
+	}
+}
+
+// jsonFormatter is JSONFormatter with the indentation and line breaks of
+// the context, calling warn (if not nil) for invalid JSON.
+func jsonFormatter(warn func(err error)) TextFormatterFunc {
+	return func(ctx TextContext, text []byte) ([]byte, error) {
+		if len(bytes.TrimSpace(text)) == 0 {
+			return nil, nil
+		}
+
+		prefix := strings.Repeat(ctx.Tab, ctx.Depth+1)
+
+		var b bytes.Buffer
+		if err := json.Indent(&b, bytes.TrimSpace(text), prefix, ctx.Tab); err != nil {
+			if warn != nil {
+				warn(fmt.Errorf("invalid JSON in script element: %w", err))
+			}
+			return text, nil
+		}
+
+		var out bytes.Buffer
+		out.WriteString(ctx.Newline + prefix)
+		// Newlines in JSON strings are escaped, so these are all line breaks.
+		out.Write(bytes.ReplaceAll(b.Bytes(), []byte("\n"), []byte(ctx.Newline)))
+		out.WriteString(ctx.Newline + strings.Repeat(ctx.Tab, ctx.Depth))
+		return out.Bytes(), nil
+	}
+}
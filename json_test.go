@@ -0,0 +1,32 @@
+package htmlfmt
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestJSONFormatter(t *testing.T) {
+	c := qt.New(t)
+
+	var warnings []error
+	formatter := jsonFormatter(func(err error) { warnings = append(warnings, err) })
+	format := func(text string, depth int, newline string) string {
+		b, err := formatter(TextContext{Depth: depth, Tab: "  ", Newline: newline}, []byte(text))
+		c.Assert(err, qt.IsNil)
+		return string(b)
+	}
+
+	c.Assert(format(`{"a":[1,2],"b":{}}`, 0, "\n"), qt.Equals, "\n  {\n    \"a\": [\n      1,\n      2\n    ],\n    \"b\": {}\n  }\n")
+	c.Assert(format("\n  [\"</\\u0073cript>\"]\n", 1, "\n"), qt.Equals, "\n    [\n      \"</\\u0073cript>\"\n    ]\n  ")
+	c.Assert(format(`{"a":["b\nc"]}`, 0, "\r\n"), qt.Equals, "\r\n  {\r\n    \"a\": [\r\n      \"b\\nc\"\r\n    ]\r\n  }\r\n")
+	c.Assert(format(" ", 0, "\n"), qt.Equals, "")
+	c.Assert(warnings, qt.HasLen, 0)
+
+	c.Assert(format(`{"a":}`, 0, "\n"), qt.Equals, `{"a":}`)
+	c.Assert(warnings, qt.HasLen, 1)
+	c.Assert(warnings[0], qt.ErrorMatches, "invalid JSON in script element: .*")
+
+	c.Assert(string(JSONFormatter("\t")([]byte(`{"a":1}`), 0)), qt.Equals, "\n\t{\n\t\t\"a\": 1\n\t}\n")
+	c.Assert(string(JSONFormatter("\t")([]byte(`{"a":}`), 0)), qt.Equals, `{"a":}`)
+}
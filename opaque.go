@@ -0,0 +1,99 @@
+package htmlfmt
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	"golang.org/x/net/html"
+)
+
+// OpaqueKind is how an opaque region is formatted, see OpaqueRegion.
+type OpaqueKind int
+
+const (
+	// OpaqueInline regions are formatted as a word in the text around
+	// them.
+	OpaqueInline OpaqueKind = iota
+
+	// OpaqueBlock regions are formatted as a block element without
+	// content, i.e. on a line of their own. In attribute values and e.g.
+	// <script> they are formatted as OpaqueInline.
+	OpaqueBlock
+)
+
+func (k OpaqueKind) tokenType() html.TokenType {
+	if k == OpaqueBlock {
+		return opaqueBlockToken
+	}
+	return html.TextToken
+}
+
+// OpaqueRegion is a part of the source, from byte offset Start up to End,
+// that is not parsed as HTML and written as is, e.g. a construct of a
+// template language unknown to the formatter.
+type OpaqueRegion struct {
+	Start int
+	End   int
+	Kind  OpaqueKind
+}
+
+type opaquePattern struct {
+	re   *regexp.Regexp
+	kind OpaqueKind
+}
+
+// WithOpaquePattern makes the formatter treat all matches of re as opaque
+// regions of the given kind, see OpaqueRegion. Matches overlapping a region
+// passed to FormatRegions or an earlier match are ignored.
+//
+// This replaces WithNewlineAttributePlaceholder, e.g. a preprocessor
+// wanting a template action on a line of its own can leave it in the
+// source and register a pattern matching it with OpaqueBlock.
+func WithOpaquePattern(re *regexp.Regexp, kind OpaqueKind) Option {
+	return func(f *Formatter) {
+		f.opaquePatterns = append(f.opaquePatterns, opaquePattern{re: re, kind: kind})
+	}
+}
+
+// FormatRegions is Format with the given regions of src written as is,
+// see OpaqueRegion. The regions must not overlap.
+func (f *Formatter) FormatRegions(dst io.Writer, src io.Reader, regions []OpaqueRegion) error {
+	return f.formatPlaceholders(dst, src, regions)
+}
+
+// opaqueRegions returns the regions and the matches of the opaque patterns
+// in src, sorted.
+func (f *Formatter) opaqueRegions(src []byte, regions []OpaqueRegion) ([]OpaqueRegion, error) {
+	all := make([]OpaqueRegion, len(regions))
+	copy(all, regions)
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Start < all[j].Start })
+
+	for i, r := range all {
+		if r.Start < 0 || r.End > len(src) || r.Start >= r.End {
+			return nil, &FormatError{Err: fmt.Errorf("invalid opaque region [%d:%d]", r.Start, r.End)}
+		}
+		if i > 0 && r.Start < all[i-1].End {
+			return nil, newFormatError(src, positionAt(src, r.Start), fmt.Errorf("opaque region [%d:%d] overlaps [%d:%d]", r.Start, r.End, all[i-1].Start, all[i-1].End))
+		}
+	}
+
+	for _, p := range f.opaquePatterns {
+		for _, m := range p.re.FindAllIndex(src, -1) {
+			if m[0] == m[1] {
+				continue
+			}
+			// The first region ending after the match starts.
+			i := sort.Search(len(all), func(i int) bool { return all[i].End > m[0] })
+			if i < len(all) && all[i].Start < m[1] {
+				continue
+			}
+			all = append(all, OpaqueRegion{})
+			copy(all[i+1:], all[i:])
+			all[i] = OpaqueRegion{Start: m[0], End: m[1], Kind: p.kind}
+		}
+	}
+
+	return all, nil
+}
@@ -0,0 +1,51 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFormatRegions(t *testing.T) {
+	c := qt.New(t)
+
+	format := func(src string, regions ...OpaqueRegion) (string, error) {
+		var b bytes.Buffer
+		err := New().FormatRegions(&b, strings.NewReader(src), regions)
+		return b.String(), err
+	}
+
+	src := "<div>[a <b]<p>x</p>[c]</div>"
+	got, err := format(src, OpaqueRegion{Start: 5, End: 11, Kind: OpaqueBlock}, OpaqueRegion{Start: 19, End: 22, Kind: OpaqueInline})
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "<div>\n  [a <b]\n  <p>x</p>\n  [c]\n</div>")
+
+	got, err = format(src)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "<div>\n  [a<b]<p>x</p>\n  [c]\n</div>")
+
+	_, err = format(src, OpaqueRegion{Start: 5, End: 100})
+	c.Assert(err, qt.Not(qt.IsNil))
+	_, err = format(src, OpaqueRegion{Start: 5, End: 11}, OpaqueRegion{Start: 8, End: 12})
+	c.Assert(err, qt.Not(qt.IsNil))
+}
+
+func TestOpaqueRegions(t *testing.T) {
+	c := qt.New(t)
+
+	f := New(
+		WithOpaquePattern(regexp.MustCompile(`b+`), OpaqueInline),
+		WithOpaquePattern(regexp.MustCompile(`[a-c]+`), OpaqueBlock),
+	)
+	regions, err := f.opaqueRegions([]byte("abbc a bb cc"), []OpaqueRegion{{Start: 10, End: 11, Kind: OpaqueBlock}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(regions, qt.DeepEquals, []OpaqueRegion{
+		{Start: 1, End: 3, Kind: OpaqueInline},
+		{Start: 5, End: 6, Kind: OpaqueBlock},
+		{Start: 7, End: 9, Kind: OpaqueInline},
+		{Start: 10, End: 11, Kind: OpaqueBlock},
+	})
+}
@@ -9,24 +9,49 @@ import (
 	"golang.org/x/net/html"
 )
 
-func newParser(src io.Reader, tab []byte) *parser {
+func newParser(src io.Reader, tab []byte, elements *elements) *parser {
 	if tab == nil {
 		tab = []byte("  ")
 	}
+	if elements == nil {
+		elements = defaultElements
+	}
 	return &parser{
 		tab:       tab,
+		elements:  elements,
 		i:         -1,
 		depth:     0,
+		pos:       position{line: 1, column: 1},
 		Tokenizer: html.NewTokenizer(src),
 	}
 }
 
 type parser struct {
 	// Configuration
-	tab []byte
+	tab      []byte
+	elements *elements
+
+	// Template actions and opaque regions, see formatPlaceholders.
+	actions     [][]byte
+	actionTypes []html.TokenType
+	textActions map[int]bool // Blocks formatted as text, see unbalancedActions.
+
+	// The names of the open elements, with "" for template blocks, see
+	// closeImplied.
+	stack          []string
+	impliedEndTags bool // Write the end tags omitted, see WithImpliedEndTags.
+
+	// Tag checking, see WithTagCheck.
+	checkTags     bool
+	open          []openElement
+	templateDepth int // The number of template blocks open.
+	tagErrors     []tagError
 
 	// Parser state.
-	counter int
+	counter  int
+	pos      position // Of the next token in the source.
+	verbatim verbatimState
+	off      bool // Set by directiveOff.
 
 	tokens tokens
 
@@ -37,6 +62,7 @@ type parser struct {
 
 	currType html.TokenType
 	prevType html.TokenType
+	raw      []byte // See Raw.
 
 	tag      Tag
 	tagName  []byte
@@ -51,59 +77,241 @@ Loop:
 	for {
 		prs.Next()
 
+		if prs.currType == html.ErrorToken {
+			err := prs.Err()
+			if err.Error() == "EOF" {
+				if prs.impliedEndTags {
+					prs.closeAllImplied()
+				}
+				if prs.checkTags {
+					prs.checkUnclosed()
+				}
+				break Loop
+			}
+			return nil, err
+		}
+
 		var depthAdjustment int
-		var inPre bool
+		verbatim := prs.isVerbatim()
 
-		switch prs.currType {
-		case html.StartTagToken:
-			if !(inPre || isVoid(string(prs.tagName))) {
-				depthAdjustment = 1
+		if prs.currType == html.TextToken && !verbatim && prs.actions != nil && !prs.inRawText() {
+			prs.trackText()
+			continue
+		}
+
+		if !verbatim {
+			switch prs.currType {
+			case html.StartTagToken:
+				if !prs.elements.isVoid(string(prs.tagName)) {
+					depthAdjustment = 1
+				}
+			case html.EndTagToken:
+				depthAdjustment = -1
 			}
+		}
 
-			if !inPre && isPreformatted(prs.tagName) {
-				inPre = true
+		if !verbatim {
+			prs.closeImplied(prs.currType, string(prs.tagName))
+			if prs.checkTags {
+				prs.checkTag()
 			}
+		}
 
-		case html.EndTagToken:
-			isEndPre := inPre && isPreformatted(prs.tagName)
-			if !isEndPre {
-				depthAdjustment = -1
-			} else {
-				inPre = false
+		prs.trackOpen(prs.currType, prs.Raw(), depthAdjustment, verbatim)
+
+		if !verbatim {
+			prs.trackStack(prs.currType, string(prs.tagName))
+		}
+
+	}
+
+	return prs.tokens, nil
+}
+
+// inRawText reports whether the current text token is the content of e.g.
+// <script>, which is not parsed as HTML.
+func (prs *parser) inRawText() bool {
+	name := string(prs.tagName)
+	return prs.prevType == html.StartTagToken && (rawTextElements[name] || escapableRawTextElements[name])
+}
+
+// trackText tracks the current text token, with any template actions
+// structuring the document, e.g. {{ range .Pages }}, and block opaque
+// regions as separate tokens.
+func (prs *parser) trackText() {
+	raw := prs.Raw()
+
+	var i int
+	for _, m := range placeholderRe.FindAllIndex(raw, -1) {
+		n := placeholderIndex(raw[m[0]:m[1]])
+		typ := prs.actionTypes[n]
+		switch typ {
+		case templateStartToken:
+			prs.templateDepth++
+		case templateEndToken:
+			if prs.templateDepth > 0 {
+				prs.templateDepth--
 			}
+		}
+		if typ == html.TextToken || prs.textActions[n] {
+			continue
+		}
 
-		case html.ErrorToken:
-			err := prs.Err()
-			if err.Error() == "EOF" {
-				break Loop
+		if i < m[0] {
+			prs.trackOpen(html.TextToken, raw[i:m[0]], 0, false)
+		}
+
+		var depthAdjustment int
+		switch typ {
+		case templateStartToken:
+			depthAdjustment = 1
+		case templateEndToken:
+			depthAdjustment = -1
+		}
+		prs.closeImplied(typ, "")
+		prs.trackOpen(typ, raw[m[0]:m[1]], depthAdjustment, false)
+		prs.trackStack(typ, "")
+
+		i = m[1]
+	}
+
+	if i < len(raw) {
+		prs.trackOpen(html.TextToken, raw[i:], 0, false)
+	}
+}
+
+// Comments controlling the formatting of the source, e.g.
+// <!-- htmlfmt-ignore -->.
+const (
+	directiveIgnore      = "htmlfmt-ignore"       // Ignore the next node.
+	directiveIgnoreStart = "htmlfmt-ignore-start" // Ignore until directiveIgnoreEnd.
+	directiveIgnoreEnd   = "htmlfmt-ignore-end"
+	directiveOff         = "htmlfmt:off" // Ignore the whole document.
+)
+
+// directive returns the trimmed text of the comment token raw.
+func directive(raw []byte) string {
+	s := string(raw)
+	s = strings.TrimPrefix(s, "<!--")
+	s = strings.TrimSuffix(s, "-->")
+	return strings.TrimSpace(s)
+}
+
+// verbatimState tracks the parts of the source written as is.
+type verbatimState struct {
+	// The element we're in, e.g. pre, and how many of those are open.
+	name    string
+	nesting int
+	// Whether the end tag of name is written as is, i.e. the element was
+	// ignored and not preformatted.
+	endTag bool
+
+	// Inside a directiveIgnoreStart region.
+	region bool
+
+	// A directiveIgnore was seen, the next node is written as is.
+	next bool
+}
+
+// isVerbatim reports whether the current token is inside a preformatted
+// element or an ignored node or region, and should be written as is.
+func (prs *parser) isVerbatim() bool {
+	v := &prs.verbatim
+	name := string(prs.tagName)
+
+	if v.name != "" {
+		switch prs.currType {
+		case html.StartTagToken:
+			if name == v.name {
+				v.nesting++
+			}
+		case html.EndTagToken:
+			if name == v.name {
+				v.nesting--
+				if v.nesting == 0 {
+					v.name = ""
+					return v.endTag
+				}
 			}
-			return nil, err
 		}
+		return true
+	}
 
-		prs.trackOpen(depthAdjustment, inPre)
+	if v.region {
+		if prs.currType == html.CommentToken && directive(prs.Raw()) == directiveIgnoreEnd {
+			v.region = false
+		}
+		return true
+	}
 
+	if v.next {
+		switch prs.currType {
+		case html.TextToken:
+			if !nonSpaceRe.Match(prs.Raw()) {
+				return false
+			}
+		case html.EndTagToken:
+			// No more nodes in the parent element.
+			v.next = false
+			return false
+		case html.StartTagToken:
+			if !prs.elements.isVoid(name) {
+				v.name, v.nesting, v.endTag = name, 1, true
+			}
+		}
+		v.next = false
+		return true
 	}
 
-	return prs.tokens, nil
+	switch prs.currType {
+	case html.CommentToken:
+		switch directive(prs.Raw()) {
+		case directiveIgnore:
+			v.next = true
+		case directiveIgnoreStart:
+			v.region = true
+		case directiveOff:
+			prs.off = true
+		}
+	case html.StartTagToken:
+		if prs.elements.isPreformatted(name) {
+			v.name, v.nesting, v.endTag = name, 1, false
+		}
+	}
+
+	return false
 }
 
-func (prs *parser) trackOpen(depthAdjustment int, inPre bool) {
-	raw := make([]byte, len(prs.Raw()))
-	copy(raw, prs.Raw())
+func (prs *parser) trackOpen(typ html.TokenType, src []byte, depthAdjustment int, verbatim bool) {
+	raw := make([]byte, len(src))
+	copy(raw, src)
 
 	t := &token{
 		i:        prs.counter,
-		inPre:    inPre,
-		typ:      prs.currType,
+		pos:      prs.pos,
+		elements: prs.elements,
+		verbatim: verbatim,
+		typ:      typ,
 		prevType: prs.prevType,
 		raw:      raw,
 		tag:      prs.tag,
-		closed:   prs.currType == html.EndTagToken,
+		closed:   typ == html.EndTagToken || typ == templateEndToken,
 	}
 
-	switch prs.currType {
-	case html.EndTagToken:
+	if typ != prs.currType {
+		// A template action split from the text.
+		t.tag = Tag{}
+	}
+
+	switch typ {
+	case html.EndTagToken, templateEndToken:
 		prs.depth += depthAdjustment
+	case templateElseToken:
+		// Dedented to the level of the block start.
+		prs.depth--
+		defer func() {
+			prs.depth++
+		}()
 	case html.TextToken:
 		t.text = prepareText(t.raw, prs.tab)
 		fallthrough
@@ -115,8 +323,11 @@ func (prs *parser) trackOpen(depthAdjustment int, inPre bool) {
 
 	t.depth = prs.depth
 	prs.counter++
+	prs.advance(src)
 
-	if t.closed && !t.inPre {
+	if typ == templateEndToken || typ == templateElseToken {
+		prs.pairAction(t)
+	} else if t.closed && !t.verbatim {
 		// Attach the start element to the end, if possible.
 		for i := len(prs.tokens) - 1; i >= 0; i-- {
 			tt := prs.tokens[i]
@@ -127,12 +338,18 @@ func (prs *parser) trackOpen(depthAdjustment int, inPre bool) {
 
 			if tt != t && tt.depth == t.depth && t.tag.Name == tt.tag.Name {
 				t.startElement = tt
+				tt.endElement = t
 				tt.closed = t.closed
 				break
 			}
 		}
 	}
 
+	prs.appendToken(t)
+}
+
+// appendToken adds t to the tokens, as a child of its parent, if any.
+func (prs *parser) appendToken(t *token) {
 	for i := len(prs.tokens) - 1; i >= 0; i-- {
 		tt := prs.tokens[i]
 
@@ -149,6 +366,48 @@ func (prs *parser) trackOpen(depthAdjustment int, inPre bool) {
 	prs.tokens = append(prs.tokens, t)
 }
 
+// advance moves the parser position past the token source src, with any
+// placeholders, see formatPlaceholders, as the actions they replace.
+func (prs *parser) advance(src []byte) {
+	var i int
+	if prs.actions != nil {
+		for _, m := range placeholderRe.FindAllIndex(src, -1) {
+			prs.pos.advance(src[i:m[0]])
+			if n := placeholderIndex(src[m[0]:m[1]]); n < len(prs.actions) {
+				prs.pos.advance(prs.actions[n])
+			} else {
+				prs.pos.advance(src[m[0]:m[1]])
+			}
+			i = m[1]
+		}
+	}
+	prs.pos.advance(src[i:])
+}
+
+// pairAction attaches the template block start to t, an {{ else }} or
+// {{ end }}, if possible.
+func (prs *parser) pairAction(t *token) {
+	for i := len(prs.tokens) - 1; i >= 0; i-- {
+		tt := prs.tokens[i]
+		if tt.typ != templateStartToken || tt.closed || tt.depth != t.depth {
+			continue
+		}
+
+		t.startElement = tt
+		if t.typ == templateEndToken {
+			tt.endElement = t
+			tt.closed = true
+			// Tokens after the end are not children of any else.
+			for _, ttt := range prs.tokens[i:] {
+				if ttt.typ == templateElseToken && ttt.startElement == tt {
+					ttt.closed = true
+				}
+			}
+		}
+		return
+	}
+}
+
 type text struct {
 	b                  []byte
 	hasNewline         bool
@@ -166,23 +425,32 @@ func (t text) IsZero() bool {
 type token struct {
 	i int
 
+	// Element classification.
+	elements *elements
+
 	// From html.Tokenizer
 	typ      html.TokenType
 	prevType html.TokenType
 	raw      []byte
+	pos      position // In the source, see parser.advance.
 
 	tag Tag
 
 	startElement *token
+	endElement   *token
 
 	sizeBytes     int
 	sizeBytesInit sync.Once
 
+	widthColumns     int
+	widthColumnsInit sync.Once
+
 	// parser state
-	inPre    bool
+	verbatim bool // Written as is, e.g. the content of <pre>.
 	depth    int
 	children tokens
 	closed   bool
+	implied  bool // An end tag omitted in the source, see closeImplied.
 
 	// formatter state
 	indented bool
@@ -194,7 +462,7 @@ func (t *token) isStartIndented() bool {
 }
 
 func (t *token) isInline() bool {
-	return isInline(t.tag.Name)
+	return t.elements.isInline(t.tag.Name)
 }
 
 func (t *token) isBlock() bool {
@@ -202,11 +470,14 @@ func (t *token) isBlock() bool {
 }
 
 func (t *token) isVoid() bool {
-	return isVoid(t.tag.Name)
+	return t.elements.isVoid(t.tag.Name)
 }
 
-func (t *token) needsNewlineAppended() bool {
-	if t.inPre {
+// needsNewlineAppended reports whether the content of t should be wrapped
+// and indented. column is the indentation of the line t starts on, and
+// printWidth the maximum line width (0 means use sizeNewlineThreshold).
+func (t *token) needsNewlineAppended(column, printWidth int) bool {
+	if t.elements.isPreformatted(t.tag.Name) {
 		return false
 	}
 
@@ -214,13 +485,13 @@ func (t *token) needsNewlineAppended() bool {
 		return false
 	}
 
-	if shouldAlwaysHaveNewlineAppended(t.tag.Name) {
+	if t.elements.shouldAlwaysHaveNewlineAppended(t.tag.Name) {
 		return true
 	}
 
 	blockCount := 0
 	for _, c := range t.children {
-		if c.typ == html.StartTagToken && !c.isInline() {
+		if (c.typ == html.StartTagToken && !c.isInline()) || c.typ == opaqueBlockToken {
 			blockCount++
 		} else if c.text.hasNewline {
 			blockCount++
@@ -230,7 +501,12 @@ func (t *token) needsNewlineAppended() bool {
 		}
 	}
 
-	return t.size() > sizeNewlineThreshold
+	if printWidth <= 0 {
+		return t.size() > sizeNewlineThreshold
+	}
+
+	// The element rendered on one line, including its end tag.
+	return column+t.width()+len(t.tag.Name)+len("</>") > printWidth
 }
 
 // size() returns the size in bytes of itself and all its descendants.
@@ -253,6 +529,30 @@ func (t *token) size() int {
 	return t.sizeBytes
 }
 
+// width() returns the width in display columns of itself and all its
+// descendants when rendered on one line.
+// As with size(), the value is cached.
+func (t *token) width() int {
+	t.widthColumnsInit.Do(func() {
+		w := 0
+		if t.typ == html.TextToken {
+			if !t.text.isWhitespaceOnly {
+				w = displayWidth(t.text.b)
+			}
+		} else {
+			w = displayWidth(t.raw)
+		}
+
+		for _, tt := range t.children {
+			w += tt.width()
+		}
+
+		t.widthColumns = w
+	})
+
+	return t.widthColumns
+}
+
 type tokenIterator struct {
 	pos    int
 	tokens []*token
@@ -267,6 +567,14 @@ func (t *tokenIterator) Next() *token {
 	return tok
 }
 
+// at returns the token at position i, or nil if out of range.
+func (t *tokenIterator) at(i int) *token {
+	if i < 0 || i >= len(t.tokens) {
+		return nil
+	}
+	return t.tokens[i]
+}
+
 func (t *tokenIterator) Current() *token {
 	tok := t.tokens[t.pos]
 	return tok
@@ -294,6 +602,19 @@ func (t *tokenIterator) PeekStart() *token {
 	}
 }
 
+// peekClosing reports whether the next token, ignoring whitespace, ends
+// an element or template block.
+func (t *tokenIterator) peekClosing() bool {
+	for i := t.pos + 1; i < len(t.tokens); i++ {
+		tok := t.tokens[i]
+		if tok.text.isWhitespaceOnly {
+			continue
+		}
+		return tok.typ == html.EndTagToken || tok.typ == templateElseToken || tok.typ == templateEndToken
+	}
+	return false
+}
+
 func (t *tokenIterator) Prev() *token {
 	if t.pos <= 0 {
 		return nil
@@ -323,43 +644,3 @@ func (t tokens) String() string {
 func (t *token) String() string {
 	return fmt.Sprintf("%s/%s(%d)", t.tag.Name, t.typ, t.depth)
 }
-
-func isInline(tag string) bool {
-	switch tag {
-	case "a", "b", "i", "em", "strong", "code", "span", "ins",
-		"big", "small", "tt", "abbr", "acronym", "cite", "dfn",
-		"kbd", "samp", "var", "bdo", "map", "q", "sub", "sup":
-		return true
-	default:
-		return false
-	}
-}
-
-func isPreformatted(tag []byte) bool {
-	switch string(tag) {
-	case "pre", "textarea", "code":
-		return true
-	default:
-		return false
-	}
-}
-
-func isVoid(tag string) bool {
-	switch string(tag) {
-	case "input", "link", "meta", "hr", "img", "br", "area", "base", "col",
-		"param", "command", "embed", "keygen", "source", "track", "wbr":
-		return true
-	default:
-		return false
-	}
-}
-
-// Even for very short examples, we would not want these on one line.
-func shouldAlwaysHaveNewlineAppended(tag string) bool {
-	switch tag {
-	case "html", "body":
-		return true
-	default:
-		return false
-	}
-}
@@ -13,7 +13,7 @@ func TestParse(t *testing.T) {
 
 	pc := func(c *qt.C, input string, matches ...string) {
 		c.Helper()
-		p := newParser(strings.NewReader(input), nil)
+		p := newParser(strings.NewReader(input), nil, nil)
 		tok, err := p.parse()
 
 		c.Assert(err, qt.IsNil)
@@ -32,6 +32,10 @@ func TestParse(t *testing.T) {
 			"START:typ(StartTag)-tag(div)-0[depth(0)|children(40)|size(285)]", "typ(EndTag)-tag(div)-61[depth(0)|children(0)|size(6)]/0///:END")
 	})
 
+	c.Run("Implied end tags", func(c *qt.C) {
+		pc(c, `<ul><li>a<li>b</ul>`, "START:typ(StartTag)-tag(ul)-0[depth(0)|children(4)|size(14)]///typ(StartTag)-tag(li)-1[depth(1)|children(1)|size(5)]///typ(Text)-tag(li)-2[depth(2)|children(0)|size(1)]///typ(EndTag)-tag(li)-3[depth(1)|children(0)|size(0)]/1///typ(StartTag)-tag(li)-4[depth(1)|children(1)|size(5)]///typ(Text)-tag(li)-5[depth(2)|children(0)|size(1)]///typ(EndTag)-tag(li)-6[depth(1)|children(0)|size(0)]/4///typ(EndTag)-tag(ul)-7[depth(0)|children(0)|size(5)]/0///:END")
+	})
+
 	c.Run("Preformatted", func(c *qt.C) {
 		pc(c, `<div><pre><div>Text</div></pre></div>`, "StartTag-div-0[1:3:25]|StartTag-pre-1[2:0:5]|StartTag-div-2[2:1:9]|Text-div-3[2:0:4]|EndTag-div-4[2:0:6]|EndTag-pre-5[1:0:6]|EndTag-div-6[1:0:6]/0|")
 	})
@@ -0,0 +1,87 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FormatError is the type of all errors returned by Formatter.Format.
+// Errors for a problem with the source, e.g. a text formatter failing,
+// have its position; errors reading the source or writing the result do
+// not.
+type FormatError struct {
+	// The name of the file formatted. This is never set by the formatter,
+	// but may be set by the caller before reporting the error.
+	Filename string
+
+	// The byte offset of the error in the source, and its line and column
+	// (in bytes), starting at 1. Line is 0 if the position is not known.
+	Offset int
+	Line   int
+	Column int
+
+	// The line of the source the error is on, if known.
+	Excerpt string
+
+	Err error
+}
+
+func (e *FormatError) Error() string {
+	var prefix string
+	if e.Filename != "" {
+		prefix = e.Filename + ":"
+	}
+	if e.Line > 0 {
+		prefix += fmt.Sprintf("%d:%d:", e.Line, e.Column)
+	}
+	if prefix == "" {
+		return e.Err.Error()
+	}
+	return prefix + " " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// position is a position in the source.
+type position struct {
+	offset int
+	line   int
+	column int
+}
+
+// advance moves p past b.
+func (p *position) advance(b []byte) {
+	p.offset += len(b)
+	if i := bytes.LastIndexByte(b, '\n'); i != -1 {
+		p.line += bytes.Count(b, []byte("\n"))
+		p.column = len(b) - i
+	} else {
+		p.column += len(b)
+	}
+}
+
+// positionAt returns the position of offset in src.
+func positionAt(src []byte, offset int) position {
+	p := position{line: 1, column: 1}
+	p.advance(src[:offset])
+	return p
+}
+
+// newFormatError returns err at pos in src as a *FormatError.
+func newFormatError(src []byte, pos position, err error) *FormatError {
+	e := &FormatError{Offset: pos.offset, Line: pos.line, Column: pos.column, Err: err}
+	if pos.line > 0 && pos.offset <= len(src) {
+		start := bytes.LastIndexByte(src[:pos.offset], '\n') + 1
+		end := bytes.IndexByte(src[pos.offset:], '\n')
+		if end == -1 {
+			end = len(src)
+		} else {
+			end += pos.offset
+		}
+		e.Excerpt = string(bytes.TrimRight(src[start:end], "\r"))
+	}
+	return e
+}
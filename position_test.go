@@ -0,0 +1,85 @@
+package htmlfmt
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestTokenPositions(t *testing.T) {
+	c := qt.New(t)
+
+	assertPositions := func(p *parser, want []position) {
+		c.Helper()
+		tokens, err := p.parse()
+		c.Assert(err, qt.IsNil)
+		c.Assert(tokens, qt.HasLen, len(want))
+		for i, t := range tokens {
+			c.Assert(t.pos, qt.Equals, want[i], qt.Commentf("token %d", i))
+		}
+	}
+
+	assertPositions(newParser(strings.NewReader("<div>\n  <p>a</p>\r\n</div>"), nil, nil), []position{
+		{0, 1, 1}, {5, 1, 6}, {8, 2, 3}, {11, 2, 6}, {12, 2, 7}, {16, 2, 11}, {18, 3, 1},
+	})
+
+	// Template actions spanning lines count as in the source.
+	src := []byte("{{ if\n.X }}<p>a</p>{{ end }}\n<b>")
+	b, actions, types, err := replacePlaceholders(src, nil, &GoTemplateSyntax)
+	c.Assert(err, qt.IsNil)
+	p := newParser(strings.NewReader(string(b)), nil, nil)
+	p.actions, p.actionTypes = actions, types
+	assertPositions(p, []position{
+		{0, 1, 1}, {11, 2, 6}, {14, 2, 9}, {15, 2, 10}, {19, 2, 14}, {28, 2, 23}, {29, 3, 1},
+	})
+}
+
+func TestImpliedEndTagPositions(t *testing.T) {
+	c := qt.New(t)
+
+	// The end tags written are not in the source.
+	src := "<ul><li>a<li>b<li>c</ul></span>"
+	for _, opts := range [][]Option{nil, {WithImpliedEndTags()}} {
+		f := New(append(opts, WithTagCheck(TagCheckStrict))...)
+		tokens, err := f.newParser([]byte(src)).parse()
+		c.Assert(err, qt.IsNil)
+		c.Assert(tokens[len(tokens)-1].pos, qt.Equals, position{24, 1, 25})
+
+		err = f.Format(ioutil.Discard, strings.NewReader(src))
+		c.Assert(err, qt.ErrorMatches, "1:25: unexpected end tag </span>")
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	c := qt.New(t)
+
+	err := errors.New("failed")
+	c.Assert((&FormatError{Err: err}).Error(), qt.Equals, "failed")
+	c.Assert((&FormatError{Filename: "a.html", Err: err}).Error(), qt.Equals, "a.html: failed")
+	c.Assert((&FormatError{Line: 3, Column: 5, Err: err}).Error(), qt.Equals, "3:5: failed")
+	c.Assert((&FormatError{Filename: "a.html", Line: 3, Column: 5, Err: err}).Error(), qt.Equals, "a.html:3:5: failed")
+	c.Assert(errors.Is(&FormatError{Err: err}, err), qt.IsTrue)
+
+	fe := newFormatError([]byte("a\r\nbcd\nef"), position{4, 2, 2}, err)
+	c.Assert(fe.Excerpt, qt.Equals, "bcd")
+	c.Assert(newFormatError([]byte("ab"), position{2, 1, 3}, err).Excerpt, qt.Equals, "ab")
+	c.Assert(positionAt([]byte("a\r\nbcd\nef"), 9), qt.Equals, position{9, 3, 3})
+
+	format := func(src string, opts ...Option) *FormatError {
+		err := New(opts...).Format(ioutil.Discard, strings.NewReader(src))
+		var fe *FormatError
+		c.Assert(errors.As(err, &fe), qt.IsTrue, qt.Commentf("%v", err))
+		return fe
+	}
+
+	fe = format("<div>Hello</div>\n  <div newline/>", WithNewlineAttributePlaceholder("newline"))
+	c.Assert(*fe, qt.Equals, FormatError{Offset: 19, Line: 2, Column: 3, Excerpt: "  <div newline/>", Err: fe.Err})
+	c.Assert(fe.Error(), qt.Equals, "2:3: newline attributes is for void attributes only")
+
+	fe = format("<div>{{ if .X }}</div>", WithGoTemplates(), WithTemplateValidation(true))
+	c.Assert(fe.Line, qt.Equals, 0)
+	c.Assert(fe.Error(), qt.Matches, "template validation: .*")
+}
@@ -0,0 +1,119 @@
+package htmlfmt
+
+import "strings"
+
+// TextFormatterRegistry holds text formatters by element name and MIME
+// type, e.g. "script" and "application/ld+json".
+// Use its Lookup method with WithContextTextFormatters.
+//
+// The zero value is an empty registry ready to use.
+type TextFormatterRegistry struct {
+	formatters map[string]map[string]ContextTextFormatter
+}
+
+// Register registers formatter for the content of the named element with
+// the given MIME type, replacing any registered before.
+// An empty MIME type registers formatter for all types of the element
+// without a formatter of their own.
+func (r *TextFormatterRegistry) Register(element, mimeType string, formatter ContextTextFormatter) {
+	if r.formatters == nil {
+		r.formatters = make(map[string]map[string]ContextTextFormatter)
+	}
+	element = strings.ToLower(element)
+	if r.formatters[element] == nil {
+		r.formatters[element] = make(map[string]ContextTextFormatter)
+	}
+	r.formatters[element][normalizeMIMEType(mimeType)] = formatter
+}
+
+// Merge registers the formatters in others in r, in order, so e.g.
+// registries from several packages can be combined.
+func (r *TextFormatterRegistry) Merge(others ...*TextFormatterRegistry) {
+	for _, other := range others {
+		if other == nil {
+			continue
+		}
+		for element, formatters := range other.formatters {
+			for mimeType, formatter := range formatters {
+				r.Register(element, mimeType, formatter)
+			}
+		}
+	}
+}
+
+// Lookup returns the formatter for the content of tag, or nil if none.
+//
+// The MIME type is that of the type attribute, without any parameters,
+// and defaults to text/javascript for <script> and text/css for <style>.
+// The JavaScript MIME types, e.g. application/javascript, are all looked
+// up as text/javascript. If there is no formatter for the MIME type, JSON
+// types such as application/ld+json are looked up as application/json.
+func (r *TextFormatterRegistry) Lookup(tag Tag) ContextTextFormatter {
+	if r == nil {
+		return nil
+	}
+	formatters := r.formatters[tag.Name]
+	if formatters == nil {
+		return nil
+	}
+
+	mimeType := normalizeMIMEType(tag.Attributes.ByKey("type").Value)
+	if mimeType == "" {
+		switch tag.Name {
+		case "script":
+			mimeType = "text/javascript"
+		case "style":
+			mimeType = "text/css"
+		}
+	}
+
+	if formatter, found := formatters[mimeType]; found {
+		return formatter
+	}
+	if strings.HasPrefix(mimeType, "application/") && strings.HasSuffix(mimeType, "+json") {
+		if formatter, found := formatters["application/json"]; found {
+			return formatter
+		}
+	}
+	return formatters[""]
+}
+
+// normalizeMIMEType returns the essence of the MIME type t, e.g.
+// text/javascript for "Application/JavaScript; charset=utf-8".
+func normalizeMIMEType(t string) string {
+	if i := strings.IndexByte(t, ';'); i != -1 {
+		t = t[:i]
+	}
+	t = strings.ToLower(strings.TrimSpace(t))
+
+	switch t {
+	case "application/ecmascript", "application/javascript", "application/x-ecmascript",
+		"application/x-javascript", "text/ecmascript", "text/javascript1.0", "text/javascript1.1",
+		"text/javascript1.2", "text/javascript1.3", "text/javascript1.4", "text/javascript1.5",
+		"text/jscript", "text/livescript", "text/x-ecmascript", "text/x-javascript":
+		return "text/javascript"
+	default:
+		return t
+	}
+}
+
+// builtinTextFormatters returns the registry of the built-in text
+// formatters enabled in f, e.g. with WithCSSFormatter.
+func (f *Formatter) builtinTextFormatters() *TextFormatterRegistry {
+	r := &TextFormatterRegistry{}
+	tab := string(f.tabStr)
+	if f.formatCSS {
+		r.Register("style", "text/css", CSSFormatter(tab))
+	}
+	if f.formatJS {
+		r.Register("script", "text/javascript", JSFormatter(tab))
+		r.Register("script", "module", JSFormatter(tab))
+	}
+	if f.formatJSON {
+		formatJSON := jsonFormatter(f.warningHandler)
+		for _, mimeType := range []string{"application/json", "text/json", "importmap", "speculationrules"} {
+			r.Register("script", mimeType, formatJSON)
+		}
+	}
+	return r
+}
@@ -0,0 +1,83 @@
+package htmlfmt
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestTextFormatterRegistry(t *testing.T) {
+	c := qt.New(t)
+
+	formatter := func(name string) TextFormatter {
+		return func(text []byte, depth int) []byte {
+			return []byte(name)
+		}
+	}
+	tag := func(name, typ string) Tag {
+		t := Tag{Name: name}
+		if typ != "" {
+			t.Attributes = Attributes{{Key: "type", Value: typ, HasValue: true}}
+		}
+		return t
+	}
+	lookup := func(r *TextFormatterRegistry, tag Tag) string {
+		if f := r.Lookup(tag); f != nil {
+			b, _ := f.FormatText(TextContext{}, nil)
+			return string(b)
+		}
+		return ""
+	}
+
+	var js, json TextFormatterRegistry
+	js.Register("script", "text/javascript", formatter("js"))
+	js.Register("script", "module", formatter("module"))
+	json.Register("SCRIPT", "application/json", formatter("json"))
+	json.Register("script", "importmap", formatter("importmap"))
+
+	c.Assert(lookup(&js, tag("script", "")), qt.Equals, "js")
+	c.Assert(lookup(&js, tag("script", "module")), qt.Equals, "module")
+	c.Assert(lookup(&js, tag("script", " Text/JavaScript")), qt.Equals, "js")
+	c.Assert(lookup(&js, tag("script", "application/javascript; charset=utf-8")), qt.Equals, "js")
+	c.Assert(lookup(&js, tag("script", "application/ld+json")), qt.Equals, "")
+	c.Assert(lookup(&js, tag("style", "")), qt.Equals, "")
+
+	var r TextFormatterRegistry
+	r.Merge(&js, &json, nil)
+	c.Assert(lookup(&r, tag("script", "")), qt.Equals, "js")
+	c.Assert(lookup(&r, tag("script", "application/ld+json")), qt.Equals, "json")
+	c.Assert(lookup(&r, tag("script", "importmap")), qt.Equals, "importmap")
+	c.Assert(lookup(&r, tag("script", "text/x-template")), qt.Equals, "")
+
+	r.Register("script", "", formatter("any"))
+	r.Register("script", "module", formatter("module2"))
+	c.Assert(lookup(&r, tag("script", "text/x-template")), qt.Equals, "any")
+	c.Assert(lookup(&r, tag("script", "module")), qt.Equals, "module2")
+	c.Assert(lookup(&js, tag("script", "module")), qt.Equals, "module")
+
+	c.Assert(lookup(nil, tag("script", "")), qt.Equals, "")
+	c.Assert(lookup(&TextFormatterRegistry{}, tag("script", "")), qt.Equals, "")
+}
+
+func TestBuiltinTextFormatters(t *testing.T) {
+	c := qt.New(t)
+
+	r := New(WithCSSFormatter(), WithJSFormatter(), WithJSONFormatter()).builtinFormatters
+	for _, test := range []struct {
+		tag   Tag
+		found bool
+	}{
+		{Tag{Name: "style"}, true},
+		{Tag{Name: "script"}, true},
+		{Tag{Name: "script", Attributes: Attributes{{Key: "type", Value: "module"}}}, true},
+		{Tag{Name: "script", Attributes: Attributes{{Key: "type", Value: "application/ld+json"}}}, true},
+		{Tag{Name: "script", Attributes: Attributes{{Key: "type", Value: "speculationrules"}}}, true},
+		{Tag{Name: "script", Attributes: Attributes{{Key: "type", Value: "text/template"}}}, false},
+		{Tag{Name: "style", Attributes: Attributes{{Key: "type", Value: "text/less"}}}, false},
+		{Tag{Name: "div"}, false},
+	} {
+		c.Assert(r.Lookup(test.tag) != nil, qt.Equals, test.found, qt.Commentf("%v", test.tag))
+	}
+
+	c.Assert(New().builtinFormatters.Lookup(Tag{Name: "script"}), qt.IsNil)
+}
@@ -0,0 +1,229 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// TemplateSyntax describes a template language, e.g. Go templates or
+// Jinja, see WithTemplateSyntax.
+//
+// The tags of the language, e.g. {% if user %}, are found using Delims and
+// Quotes. The BlockStart, BlockElse and BlockEnd expressions are matched
+// against the content of the tags, without delimiters, trim markers and
+// surrounding whitespace, e.g. "if user".
+type TemplateSyntax struct {
+	// The delimiters of the tags. At any position, the longest matching
+	// left delimiter is used, e.g. {{{ before {{.
+	Delims []TemplateDelims
+
+	// Delimiters enclosing string literals and comments inside tags, in
+	// which right delimiters are ignored, e.g. " and ".
+	// In literals quoted with " or ', a backslash escapes the next
+	// character.
+	Quotes []TemplateDelims
+
+	// Characters next to the delimiters controlling whitespace, e.g. "-"
+	// in {{- .Title -}}.
+	TrimMarkers string
+
+	// Tags starting a block, e.g. {% for item in items %}.
+	BlockStart *regexp.Regexp
+
+	// Tags continuing a block at the level of its start, e.g. {% else %}.
+	BlockElse *regexp.Regexp
+
+	// Tags ending a block, e.g. {% endfor %}.
+	BlockEnd *regexp.Regexp
+
+	// Whether this is Go's text/template syntax, see WithTemplateValidation.
+	goTemplate bool
+}
+
+// TemplateDelims is a pair of left and right delimiters.
+type TemplateDelims struct {
+	Left  string
+	Right string
+
+	// Comment is set for comments, e.g. {# and #} in Jinja, which are never
+	// blocks and may contain unbalanced quotes.
+	Comment bool
+}
+
+// Built-in template syntaxes.
+var (
+	// GoTemplateSyntax is text/template and html/template.
+	GoTemplateSyntax = goTemplateSyntax("{{", "}}")
+
+	// JinjaSyntax is Jinja, also used by e.g. Nunjucks and Twig.
+	JinjaSyntax = TemplateSyntax{
+		Delims:      []TemplateDelims{{Left: "{#", Right: "#}", Comment: true}, {Left: "{%", Right: "%}"}, {Left: "{{", Right: "}}"}},
+		Quotes:      []TemplateDelims{{Left: `"`, Right: `"`}, {Left: `'`, Right: `'`}},
+		TrimMarkers: "-+",
+		BlockStart:  regexp.MustCompile(`^((if|for|block|macro|call|filter|with|raw|autoescape|trans)\b|set\s+[\w\s,]+$)`),
+		BlockElse:   regexp.MustCompile(`^(else|elif|pluralize)\b`),
+		BlockEnd:    regexp.MustCompile(`^end\w+\b`),
+	}
+
+	// DjangoSyntax is the Django template language.
+	DjangoSyntax = TemplateSyntax{
+		Delims:     []TemplateDelims{{Left: "{#", Right: "#}", Comment: true}, {Left: "{%", Right: "%}"}, {Left: "{{", Right: "}}"}},
+		Quotes:     []TemplateDelims{{Left: `"`, Right: `"`}, {Left: `'`, Right: `'`}},
+		BlockStart: regexp.MustCompile(`^(if|for|block|with|autoescape|filter|spaceless|comment|verbatim|ifchanged|blocktrans|blocktranslate)\b`),
+		BlockElse:  regexp.MustCompile(`^(else|elif|empty|plural)\b`),
+		BlockEnd:   regexp.MustCompile(`^end\w+\b`),
+	}
+
+	// HandlebarsSyntax is Handlebars.
+	HandlebarsSyntax = TemplateSyntax{
+		Delims: []TemplateDelims{
+			{Left: "{{!--", Right: "--}}", Comment: true}, {Left: "{{!", Right: "}}", Comment: true},
+			{Left: "{{{", Right: "}}}"}, {Left: "{{", Right: "}}"},
+		},
+		Quotes:      []TemplateDelims{{Left: `"`, Right: `"`}, {Left: `'`, Right: `'`}},
+		TrimMarkers: "~",
+		BlockStart:  regexp.MustCompile(`^(#|\^\S)`),
+		BlockElse:   regexp.MustCompile(`^(else\b|\^$)`),
+		BlockEnd:    regexp.MustCompile(`^/`),
+	}
+
+	// MustacheSyntax is Mustache.
+	MustacheSyntax = TemplateSyntax{
+		Delims: []TemplateDelims{
+			{Left: "{{!", Right: "}}", Comment: true},
+			{Left: "{{{", Right: "}}}"}, {Left: "{{", Right: "}}"},
+		},
+		BlockStart: regexp.MustCompile(`^[#^]`),
+		BlockEnd:   regexp.MustCompile(`^/`),
+	}
+
+	// ERBSyntax is Embedded Ruby.
+	ERBSyntax = TemplateSyntax{
+		Delims:      []TemplateDelims{{Left: "<%#", Right: "%>", Comment: true}, {Left: "<%", Right: "%>"}},
+		Quotes:      []TemplateDelims{{Left: `"`, Right: `"`}, {Left: `'`, Right: `'`}},
+		TrimMarkers: "-=",
+		BlockStart:  regexp.MustCompile(`^(if|unless|while|until|case|for|begin)\b|\bdo(\s*\|[^|]*\|)?$`),
+		BlockElse:   regexp.MustCompile(`^(else|elsif|when|rescue|ensure)\b`),
+		BlockEnd:    regexp.MustCompile(`^end\b`),
+	}
+)
+
+func goTemplateSyntax(left, right string) TemplateSyntax {
+	return TemplateSyntax{
+		Delims:      []TemplateDelims{{Left: left, Right: right}},
+		Quotes:      []TemplateDelims{{Left: `"`, Right: `"`}, {Left: `'`, Right: `'`}, {Left: "`", Right: "`"}, {Left: "/*", Right: "*/"}},
+		TrimMarkers: "-",
+		BlockStart:  regexp.MustCompile(`^(if|range|with|define|block)\b`),
+		BlockElse:   regexp.MustCompile(`^else\b`),
+		BlockEnd:    regexp.MustCompile(`^end\b`),
+		goTemplate:  true,
+	}
+}
+
+// delimsAt returns the delimiters with the longest left delimiter at the
+// start of b, or nil if none.
+func (s *TemplateSyntax) delimsAt(b []byte) *TemplateDelims {
+	var found *TemplateDelims
+	for i, d := range s.Delims {
+		if d.Left == "" || d.Right == "" {
+			continue
+		}
+		if bytes.HasPrefix(b, []byte(d.Left)) && (found == nil || len(d.Left) > len(found.Left)) {
+			found = &s.Delims[i]
+		}
+	}
+	return found
+}
+
+// nextTag returns the start and end of the first tag in src at or after
+// i, or -1, -1 if none.
+// A left delimiter without a matching right delimiter ends the search.
+func (s *TemplateSyntax) nextTag(src []byte, i int) (int, int) {
+	var first []byte
+	for _, d := range s.Delims {
+		if d.Left != "" {
+			first = append(first, d.Left[0])
+		}
+	}
+
+	for i < len(src) {
+		j := bytes.IndexAny(src[i:], string(first))
+		if j == -1 {
+			break
+		}
+		start := i + j
+		if d := s.delimsAt(src[start:]); d != nil {
+			end := s.tagEnd(src, start+len(d.Left), d)
+			if end == -1 {
+				break
+			}
+			return start, end
+		}
+		i = start + 1
+	}
+	return -1, -1
+}
+
+// tagEnd returns the position after the right delimiter of the tag with
+// content starting at i, or -1 if not found.
+func (s *TemplateSyntax) tagEnd(src []byte, i int, d *TemplateDelims) int {
+	right := []byte(d.Right)
+	if d.Comment {
+		if end := bytes.Index(src[i:], right); end != -1 {
+			return i + end + len(right)
+		}
+		return -1
+	}
+
+Scan:
+	for i < len(src) {
+		if bytes.HasPrefix(src[i:], right) {
+			return i + len(right)
+		}
+		for _, q := range s.Quotes {
+			if q.Left == "" || !bytes.HasPrefix(src[i:], []byte(q.Left)) {
+				continue
+			}
+			escapes := q.Left == `"` || q.Left == `'`
+			for i += len(q.Left); i < len(src) && !bytes.HasPrefix(src[i:], []byte(q.Right)); i++ {
+				if escapes && src[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(src) {
+				return -1
+			}
+			i += len(q.Right)
+			continue Scan
+		}
+		i++
+	}
+	return -1
+}
+
+// tokenType returns the token type of the template tag, or html.TextToken
+// for tags that are part of the text, e.g. {{ .Title }}.
+func (s *TemplateSyntax) tokenType(tag []byte) html.TokenType {
+	d := s.delimsAt(tag)
+	if d == nil || d.Comment || len(tag) < len(d.Left)+len(d.Right) {
+		return html.TextToken
+	}
+
+	content := tag[len(d.Left) : len(tag)-len(d.Right)]
+	content = bytes.TrimLeft(content, s.TrimMarkers)
+	content = bytes.TrimRight(content, s.TrimMarkers)
+	content = bytes.TrimSpace(content)
+
+	switch {
+	case s.BlockStart != nil && s.BlockStart.Match(content):
+		return templateStartToken
+	case s.BlockElse != nil && s.BlockElse.Match(content):
+		return templateElseToken
+	case s.BlockEnd != nil && s.BlockEnd.Match(content):
+		return templateEndToken
+	default:
+		return html.TextToken
+	}
+}
@@ -0,0 +1,311 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+)
+
+// attributeSource holds an attribute as written in the source.
+type attributeSource struct {
+	key      string // The key before lower casing.
+	value    string // The value before unescaping, without quotes.
+	decoded  string // The value as returned from the tokenizer.
+	quote    byte   // One of ', " or 0 (unquoted).
+	hasValue bool   // Whether the attribute had a value, e.g. not <input disabled>.
+}
+
+func isTagSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f'
+}
+
+// scanTag reads the tag name and attributes of the start tag in raw,
+// e.g. <div class="foo">, following the same rules as html.Tokenizer.
+// spaceBeforeSlash is set for self-closing tags written as e.g. <br />.
+func scanTag(raw []byte) (name string, attrs []attributeSource, spaceBeforeSlash bool) {
+	i, n := 0, len(raw)
+	if i < n && raw[i] == '<' {
+		i++
+	}
+
+	start := i
+	for i < n && !isTagSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' {
+		i++
+	}
+	name = string(raw[start:i])
+
+	skipSpace := func() {
+		for i < n && isTagSpace(raw[i]) {
+			i++
+		}
+	}
+
+	skipSpace()
+	for i < n && raw[i] != '>' {
+		var attr attributeSource
+
+		// Key.
+		start = i
+		end := n
+	Key:
+		for ; i < n; i++ {
+			switch raw[i] {
+			case ' ', '\n', '\r', '\t', '\f', '/':
+				end = i
+				i++
+				break Key
+			case '=':
+				if i == start {
+					// An equals sign before the key starts is part of the key.
+					continue
+				}
+				end = i
+				break Key
+			case '>':
+				end = i
+				break Key
+			}
+		}
+		attr.key = string(raw[start:end])
+
+		// Value.
+		skipSpace()
+		if i < n && raw[i] == '=' {
+			i++
+			skipSpace()
+			if i < n && raw[i] != '>' {
+				attr.hasValue = true
+				if q := raw[i]; q == '"' || q == '\'' {
+					attr.quote = q
+					i++
+					start = i
+					for i < n && raw[i] != q {
+						i++
+					}
+					attr.value = string(raw[start:i])
+					if i < n {
+						i++
+					}
+				} else {
+					start = i
+					for i < n && !isTagSpace(raw[i]) && raw[i] != '>' {
+						i++
+					}
+					attr.value = string(raw[start:i])
+				}
+			}
+		}
+
+		if attr.key != "" {
+			attrs = append(attrs, attr)
+		}
+
+		skipSpace()
+	}
+
+	if n >= 3 && raw[n-2] == '/' && raw[n-1] == '>' {
+		spaceBeforeSlash = isTagSpace(raw[n-3])
+	}
+
+	return
+}
+
+// sort sorts a in place by the first pattern in patterns matching the key,
+// then by key. Keys not matching any pattern are sorted last.
+func (a Attributes) sort(patterns []string) {
+	rank := func(key string) int {
+		for i, pattern := range patterns {
+			if pattern == key {
+				return i
+			}
+			if matched, _ := path.Match(pattern, key); matched {
+				return i
+			}
+		}
+		return len(patterns)
+	}
+
+	ranks := make(map[string]int, len(a))
+	for _, attr := range a {
+		ranks[attr.Key] = rank(attr.Key)
+	}
+
+	sort.SliceStable(a, func(i, j int) bool {
+		ri, rj := ranks[a[i].Key], ranks[a[j].Key]
+		if ri != rj {
+			return ri < rj
+		}
+		return a[i].Key < a[j].Key
+	})
+}
+
+// needsQuotes reports whether the attribute value v cannot be written
+// unquoted.
+func needsQuotes(v string) bool {
+	return v == "" || strings.ContainsAny(v, " \t\n\r\f\"'=<>`")
+}
+
+// escapeQuote escapes any occurrence of quote in the attribute value v.
+func escapeQuote(v string, quote byte) string {
+	switch quote {
+	case '"':
+		return strings.Replace(v, `"`, "&quot;", -1)
+	case '\'':
+		return strings.Replace(v, `'`, "&#39;", -1)
+	default:
+		return v
+	}
+}
+
+// appendAttribute renders a to dst.
+// The value of attributes that are unchanged since parsing is written
+// as in the source, with any entities preserved.
+func appendAttribute(dst []byte, a Attribute) []byte {
+	if a.src.key != "" && strings.EqualFold(a.src.key, a.Key) {
+		dst = append(dst, a.src.key...)
+	} else {
+		dst = append(dst, a.Key...)
+	}
+
+	if !a.HasValue && a.Value == "" {
+		return dst
+	}
+
+	unchanged := a.src.key != "" && a.Value == a.src.decoded
+
+	var value string
+	if unchanged {
+		value = a.src.value
+	} else {
+		value = strings.Replace(a.Value, "&", "&amp;", -1)
+	}
+
+	quote := a.Quote
+	if quote == 0 && (!unchanged || needsQuotes(value)) {
+		quote = '"'
+	}
+
+	dst = append(dst, '=')
+	if quote == 0 {
+		return append(dst, value...)
+	}
+	dst = append(dst, quote)
+	dst = append(dst, escapeQuote(value, quote)...)
+	return append(dst, quote)
+}
+
+// normalizeQuotes sets the quote character of all attributes with a value
+// to quote, or to the other quote character if the value contains quote
+// but not the other.
+func (a Attributes) normalizeQuotes(quote byte) {
+	other := byte('\'')
+	if quote == '\'' {
+		other = '"'
+	}
+	for i, attr := range a {
+		if !attr.HasValue && attr.Value == "" || hasPlaceholder(attr.Value) {
+			// The actions in a value may contain any quotes.
+			continue
+		}
+		a[i].Quote = quote
+		if strings.IndexByte(attr.Value, quote) != -1 && strings.IndexByte(attr.Value, other) == -1 {
+			a[i].Quote = other
+		}
+	}
+}
+
+// hasPlaceholderKeys reports whether there are template actions between
+// the attributes in a, e.g. <div {{ if .X }}id="a"{{ end }}>, which the
+// attributes can not be moved across.
+func (a Attributes) hasPlaceholderKeys() bool {
+	for _, attr := range a {
+		if hasPlaceholder(attr.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeBooleans rewrites the boolean attributes in a, e.g. disabled,
+// to the given style. Attributes with a value other than the empty string
+// or the attribute name are left alone.
+func (a Attributes) normalizeBooleans(style BooleanAttributeStyle) {
+	for i, attr := range a {
+		if !isBooleanAttribute(attr.Key) || !(attr.Value == "" || strings.EqualFold(attr.Value, attr.Key)) {
+			continue
+		}
+		switch style {
+		case BooleanAttributeMinimized:
+			a[i].Value, a[i].HasValue = "", false
+		case BooleanAttributeEmpty:
+			a[i].Value, a[i].HasValue = "", true
+		case BooleanAttributeName:
+			a[i].Value, a[i].HasValue = attr.Key, true
+		}
+	}
+}
+
+// Boolean attributes as defined in the HTML Living Standard.
+func isBooleanAttribute(key string) bool {
+	switch key {
+	case "allowfullscreen", "async", "autofocus", "autoplay", "checked",
+		"controls", "default", "defer", "disabled", "formnovalidate",
+		"hidden", "inert", "ismap", "itemscope", "loop", "multiple", "muted",
+		"nomodule", "novalidate", "open", "playsinline", "readonly",
+		"required", "reversed", "selected", "shadowrootclonable",
+		"shadowrootdelegatesfocus", "shadowrootserializable":
+		return true
+	default:
+		return false
+	}
+}
+
+// appendStartTag renders the start tag t to dst.
+// attrSep is written before every attribute and bracketSep before the
+// closing bracket.
+//
+// A tag that is unchanged since parsing renders as in the source, apart
+// from the whitespace between attributes, which is replaced by attrSep.
+func appendStartTag(dst []byte, t Tag, attrSep, bracketSep []byte) []byte {
+	dst = append(dst, '<')
+	if t.rawName != "" && strings.EqualFold(t.rawName, t.Name) {
+		dst = append(dst, t.rawName...)
+	} else {
+		dst = append(dst, t.Name...)
+	}
+
+	for _, a := range t.Attributes {
+		dst = append(dst, attrSep...)
+		dst = appendAttribute(dst, a)
+	}
+
+	dst = append(dst, bracketSep...)
+	if t.SelfClosing {
+		switch {
+		case len(bracketSep) > 0:
+		case t.rawName == "" || t.spaceBeforeSlash:
+			dst = append(dst, ' ')
+		case dst[len(dst)-1] == '/':
+			// An unquoted value ending with a slash, e.g. <a href=/>, which
+			// html.Tokenizer reports as self-closing.
+			return append(dst, '>')
+		}
+		dst = append(dst, '/')
+	}
+
+	return append(dst, '>')
+}
+
+// wrapStartTag renders the start tag t with one attribute per line,
+// indented one level past the tag at depth.
+func wrapStartTag(t Tag, tabStr, newline []byte, depth int, bracketSameLine bool) []byte {
+	attrSep := append(append([]byte{}, newline...), bytes.Repeat(tabStr, depth+1)...)
+	var bracketSep []byte
+	if !bracketSameLine {
+		bracketSep = append(append([]byte{}, newline...), bytes.Repeat(tabStr, depth)...)
+	} else if t.SelfClosing {
+		bracketSep = []byte(" ")
+	}
+	return appendStartTag(nil, t, attrSep, bracketSep)
+}
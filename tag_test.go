@@ -0,0 +1,137 @@
+package htmlfmt
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestScanTag(t *testing.T) {
+	c := qt.New(t)
+
+	assertAttrs := func(got, want []attributeSource) {
+		c.Helper()
+		c.Assert(got, qt.HasLen, len(want))
+		for i := range want {
+			c.Assert(got[i], qt.Equals, want[i])
+		}
+	}
+
+	name, attrs, _ := scanTag([]byte(`<DIV Class="a b" id='c' data-x=1 hidden data-y = "2"/>`))
+	c.Assert(name, qt.Equals, "DIV")
+	assertAttrs(attrs, []attributeSource{
+		{key: "Class", value: "a b", quote: '"', hasValue: true},
+		{key: "id", value: "c", quote: '\'', hasValue: true},
+		{key: "data-x", value: "1", hasValue: true},
+		{key: "hidden"},
+		{key: "data-y", value: "2", quote: '"', hasValue: true},
+	})
+
+	name, attrs, spaceBeforeSlash := scanTag([]byte(`<br/>`))
+	c.Assert(name, qt.Equals, "br")
+	c.Assert(attrs, qt.HasLen, 0)
+	c.Assert(spaceBeforeSlash, qt.IsFalse)
+	_, _, spaceBeforeSlash = scanTag([]byte(`<br />`))
+	c.Assert(spaceBeforeSlash, qt.IsTrue)
+
+	_, attrs, _ = scanTag([]byte(`<a title="x>y" =b>`))
+	assertAttrs(attrs, []attributeSource{
+		{key: "title", value: "x>y", quote: '"', hasValue: true},
+		{key: "=b"},
+	})
+}
+
+func TestAttributeSource(t *testing.T) {
+	c := qt.New(t)
+
+	// The source of every attribute is kept, whatever the tokenizer does
+	// to its buffer, e.g. unescaping entities in a value before others.
+	for _, raw := range []string{
+		`<input class='a' value=1 title='Say "Hi"' alt="It's" data-x='&amp;"&#39;' disabled>`,
+		`<a href="/?a=1&amp;b=2&lt;c" title=&quot;x ID=y hidden>`,
+		`<div class="a" class='b' data-x=&#x26; checked="">`,
+		`<p title="日本語" lang=ja>`,
+	} {
+		p := newParser(strings.NewReader(raw), nil, nil)
+		toks, err := p.parse()
+		c.Assert(err, qt.IsNil)
+		c.Assert(toks, qt.HasLen, 1)
+		_, attrs, _ := scanTag([]byte(raw))
+		c.Assert(toks[0].tag.Attributes, qt.HasLen, len(attrs))
+		for i, attr := range toks[0].tag.Attributes {
+			c.Assert(attr.src.key, qt.Equals, attrs[i].key, qt.Commentf(raw))
+			c.Assert(attr.Quote, qt.Equals, attrs[i].quote, qt.Commentf(raw))
+			c.Assert(attr.HasValue, qt.Equals, attrs[i].hasValue, qt.Commentf(raw))
+		}
+		c.Assert(string(appendStartTag(nil, toks[0].tag, []byte(" "), nil)), qt.Equals, raw)
+	}
+}
+
+func TestAppendStartTag(t *testing.T) {
+	c := qt.New(t)
+
+	render := func(tag Tag) string {
+		return string(appendStartTag(nil, tag, []byte(" "), nil))
+	}
+
+	c.Assert(render(Tag{Name: "div", Attributes: Attributes{{Key: "class", Value: `a "b" & c`}}}), qt.Equals, `<div class="a &quot;b&quot; &amp; c">`)
+	c.Assert(render(Tag{Name: "br", SelfClosing: true}), qt.Equals, `<br />`)
+	c.Assert(render(Tag{Name: "input", Attributes: Attributes{{Key: "disabled"}, {Key: "value", HasValue: true}, {Key: "x", Value: "a'b", Quote: '\''}}}), qt.Equals, `<input disabled value="" x='a&#39;b'>`)
+
+	c.Assert(string(wrapStartTag(Tag{Name: "div", Attributes: Attributes{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}}, []byte("  "), []byte("\n"), 1, false)),
+		qt.Equals, strings.Join([]string{`<div`, `    a="1"`, `    b="2"`, `  >`}, "\n"))
+}
+
+func TestSortAttributes(t *testing.T) {
+	c := qt.New(t)
+
+	keys := func(a Attributes) []string {
+		var k []string
+		for _, attr := range a {
+			k = append(k, attr.Key)
+		}
+		return k
+	}
+
+	attrs := func(keys ...string) Attributes {
+		var a Attributes
+		for _, k := range keys {
+			a = append(a, Attribute{Key: k})
+		}
+		return a
+	}
+
+	a := attrs("title", "data-b", "class", "aria-label", "data-a", "id", "alt")
+	a.sort([]string{"id", "name", "class", "data-*", "aria-*"})
+	c.Assert(keys(a), qt.DeepEquals, []string{"id", "class", "data-a", "data-b", "aria-label", "alt", "title"})
+
+	a = attrs("b", "a", "[invalid")
+	a.sort([]string{"[invalid"})
+	c.Assert(keys(a), qt.DeepEquals, []string{"[invalid", "a", "b"})
+}
+
+func TestStartTagRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	for _, raw := range []string{
+		`<div>`,
+		`<DIV Class="Foo">`,
+		`<div class="foo" id='bar' data-x=1 hidden>`,
+		`<a href="/?a=1&amp;b=2" title='Say "Hi"'>`,
+		`<input type="checkbox" checked disabled="">`,
+		`<br>`,
+		`<br/>`,
+		`<br />`,
+		`<img src="a.png" alt=""/>`,
+		`<a href=/>`,
+		`<div data-json='{"a": "b"}'>`,
+		`<p title="日本語">`,
+	} {
+		p := newParser(strings.NewReader(raw), nil, nil)
+		toks, err := p.parse()
+		c.Assert(err, qt.IsNil)
+		c.Assert(toks, qt.HasLen, 1)
+		c.Assert(string(appendStartTag(nil, toks[0].tag, []byte(" "), nil)), qt.Equals, raw)
+	}
+}
@@ -0,0 +1,386 @@
+package htmlfmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// WithGoTemplates makes the formatter aware of Go template actions, e.g.
+// {{ .Title }} or {{- range .Pages -}}, in text and attribute values.
+// Actions, including any trim markers, are never split or reindented and
+// are written as in the source.
+//
+// The content of blocks, e.g. {{ range .Pages }} .. {{ end }}, is indented
+// as the content of an element, with any {{ else }} at the level of the
+// block. Blocks that do not match the HTML structure, e.g.
+// {{ if .X }}<div class="a">{{ else }}<div class="b">{{ end }}, are
+// formatted as text.
+//
+// The default delimiters are {{ and }}, see WithTemplateDelims.
+// For other template languages, see WithTemplateSyntax.
+func WithGoTemplates() Option {
+	return WithTemplateSyntax(GoTemplateSyntax)
+}
+
+// WithTemplateDelims is WithGoTemplates with the given action delimiters,
+// see text/template.Template.Delims. An empty delimiter means the default.
+func WithTemplateDelims(left, right string) Option {
+	if left == "" {
+		left = "{{"
+	}
+	if right == "" {
+		right = "}}"
+	}
+	return WithTemplateSyntax(goTemplateSyntax(left, right))
+}
+
+// WithTemplateSyntax is WithGoTemplates for the given template language,
+// e.g. JinjaSyntax.
+func WithTemplateSyntax(s TemplateSyntax) Option {
+	return func(f *Formatter) {
+		f.template = &s
+	}
+}
+
+// WithTemplateValidation makes Format check that the formatted template
+// is the same template as the source, i.e. that the two parse to the same
+// text/template/parse trees. Text is compared with whitespace collapsed,
+// and whitespace next to tags is ignored, but not whitespace between or
+// next to actions, unless trimmed with {{- and -}}.
+// If not, or the source is not a valid template, Format returns an error
+// and writes nothing.
+//
+// It requires Go templates, see WithGoTemplates and WithTemplateDelims.
+// With another or no template syntax, Format returns an error.
+func WithTemplateValidation(validate bool) Option {
+	return func(f *Formatter) {
+		f.validateTemplates = validate
+	}
+}
+
+// The formatter never sees the template tags and opaque regions, they are
+// replaced with placeholders before parsing and restored when done. A
+// placeholder is the index of the replaced bytes wrapped in two runes from
+// the Unicode Private Use Area, padded to their width so the line widths
+// stay the same.
+const (
+	placeholderStart = '\uE000'
+	placeholderEnd   = '\uE001'
+	placeholderPad   = '_'
+)
+
+var placeholderRe = regexp.MustCompile("\uE000[0-9]+_*\uE001")
+
+const reservedRunes = string(placeholderStart) + string(placeholderEnd)
+
+// hasPlaceholder reports whether s contains a placeholder.
+func hasPlaceholder(s string) bool {
+	return strings.ContainsRune(s, placeholderStart)
+}
+
+// replaceActions returns src with the template tags replaced by
+// placeholders, and the tags in order.
+func (s *TemplateSyntax) replaceActions(src []byte) ([]byte, [][]byte, error) {
+	b, actions, _, err := replacePlaceholders(src, nil, s)
+	return b, actions, err
+}
+
+// replacePlaceholders returns src with the regions, and the template tags
+// of s (if not nil) between them, replaced by placeholders. It also
+// returns the replaced bytes in order and their token types.
+// The regions must be sorted and not overlap, see Formatter.opaqueRegions.
+func replacePlaceholders(src []byte, regions []OpaqueRegion, s *TemplateSyntax) ([]byte, [][]byte, []html.TokenType, error) {
+	var (
+		b       bytes.Buffer
+		actions [][]byte
+		types   []html.TokenType
+		i       int
+	)
+	replace := func(start, end int, typ html.TokenType) {
+		b.Write(src[i:start])
+		writePlaceholder(&b, len(actions), src[start:end])
+		actions = append(actions, src[start:end])
+		types = append(types, typ)
+		i = end
+	}
+	// The placeholder runes already in the source, e.g. icon font glyphs,
+	// are replaced like inline opaque regions.
+	replaceReserved := func(end int) {
+		for {
+			j := bytes.IndexAny(src[i:end], reservedRunes)
+			if j == -1 {
+				return
+			}
+			_, size := utf8.DecodeRune(src[i+j:])
+			replace(i+j, i+j+size, OpaqueInline.tokenType())
+		}
+	}
+
+	for r := 0; r <= len(regions); r++ {
+		gapEnd := len(src)
+		if r < len(regions) {
+			gapEnd = regions[r].Start
+		}
+
+		if s != nil {
+			for {
+				start, end := s.nextTag(src[:gapEnd], i)
+				if start == -1 {
+					break
+				}
+				replaceReserved(start)
+				replace(start, end, s.tokenType(src[start:end]))
+			}
+		}
+		replaceReserved(gapEnd)
+
+		if r < len(regions) {
+			replace(regions[r].Start, regions[r].End, regions[r].Kind.tokenType())
+		}
+	}
+	b.Write(src[i:])
+
+	return b.Bytes(), actions, types, nil
+}
+
+func writePlaceholder(b *bytes.Buffer, i int, action []byte) {
+	n := strconv.Itoa(i)
+	b.WriteRune(placeholderStart)
+	b.WriteString(n)
+
+	if nl := bytes.IndexByte(action, '\n'); nl != -1 {
+		action = action[:nl]
+	}
+	for pad := displayWidth(action) - len(n) - 2; pad > 0; pad-- {
+		b.WriteByte(placeholderPad)
+	}
+
+	b.WriteRune(placeholderEnd)
+}
+
+// placeholderIndex returns the action index in the placeholder p.
+func placeholderIndex(p []byte) int {
+	// Both runes are 3 bytes in UTF-8.
+	i, _ := strconv.Atoi(string(bytes.TrimRight(p[3:len(p)-3], string(placeholderPad))))
+	return i
+}
+
+// restoreActions replaces the placeholders in b with the actions.
+func restoreActions(b []byte, actions [][]byte) []byte {
+	return placeholderRe.ReplaceAllFunc(b, func(m []byte) []byte {
+		if i := placeholderIndex(m); i < len(actions) {
+			return actions[i]
+		}
+		return m
+	})
+}
+
+// formatPlaceholders formats src with its template actions and opaque
+// regions, see FormatRegions, kept as is.
+func (f *Formatter) formatPlaceholders(dst io.Writer, src io.Reader, regions []OpaqueRegion) error {
+	source, err := ioutil.ReadAll(src)
+	if err != nil {
+		return &FormatError{Err: err}
+	}
+
+	if regions, err = f.opaqueRegions(source, regions); err != nil {
+		return err
+	}
+
+	b, actions, types, err := replacePlaceholders(source, regions, f.template)
+	if err != nil {
+		return err
+	}
+
+	var (
+		p      *parser
+		tokens tokens
+		text   = make(map[int]bool)
+	)
+	for {
+		p = f.newParser(b)
+		p.actions, p.actionTypes, p.textActions = actions, types, text
+		if tokens, err = p.parse(); err != nil {
+			return newFormatError(source, p.pos, err)
+		}
+		if !unbalancedActions(tokens, text) {
+			break
+		}
+	}
+	if err := f.reportTagErrors(source, p); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := f.formatTokens(&buf, tokens, p.off, source); err != nil {
+		return err
+	}
+
+	formatted := restoreActions(buf.Bytes(), actions)
+
+	if f.validateTemplates {
+		if err := f.template.validate(source, formatted, actions); err != nil {
+			return &FormatError{Err: err}
+		}
+	}
+
+	if _, err := dst.Write(formatted); err != nil {
+		return &FormatError{Err: err}
+	}
+	return nil
+}
+
+// Token types for the template actions and opaque regions structuring the
+// document, in addition to those in html.
+const (
+	templateStartToken html.TokenType = iota + 100 // E.g. {{ range .Pages }}.
+	templateElseToken                              // E.g. {{ else if .Draft }}.
+	templateEndToken                               // {{ end }}.
+	opaqueBlockToken                               // See OpaqueBlock.
+)
+
+// unbalancedActions adds the template blocks in tokens that do not
+// match the HTML structure to text, e.g. {{ if .X }}<div class="a">{{ else }}
+// <div class="b">{{ end }}, and reports whether any were added.
+// The actions in text are formatted as part of the text, see
+// parser.trackText.
+func unbalancedActions(tokens tokens, text map[int]bool) bool {
+	var found bool
+	for _, start := range tokens {
+		if start.typ != templateStartToken {
+			continue
+		}
+
+		end := start.endElement
+		balanced := end != nil
+		if balanced {
+			for _, t := range tokens[start.i+1 : end.i] {
+				if t.verbatim {
+					continue
+				}
+				switch {
+				case t.typ == html.StartTagToken && !t.isVoid():
+					balanced = t.endElement != nil && t.endElement.i < end.i
+				case t.typ == html.EndTagToken:
+					balanced = t.startElement != nil && t.startElement.i > start.i
+				}
+				if !balanced {
+					break
+				}
+			}
+		}
+		if balanced {
+			continue
+		}
+
+		found = true
+		text[placeholderIndex(start.raw)] = true
+		for _, t := range tokens[start.i+1:] {
+			if (t.typ == templateElseToken || t.typ == templateEndToken) && t.startElement == start {
+				text[placeholderIndex(t.raw)] = true
+			}
+		}
+	}
+	return found
+}
+
+var identifierRe = regexp.MustCompile(`[\pL_][\pL\pN_]*`)
+
+// validate returns an error if formatted is not the same Go template as
+// source, see WithTemplateValidation.
+func (s *TemplateSyntax) validate(source, formatted []byte, actions [][]byte) error {
+	// We don't know the template functions, so accept any identifier.
+	funcs := make(map[string]interface{})
+	for _, a := range actions {
+		for _, name := range identifierRe.FindAll(a, -1) {
+			funcs[string(name)] = true
+		}
+	}
+
+	canonical := func(b []byte) (string, error) {
+		trees, err := parse.Parse("", string(b), s.Delims[0].Left, s.Delims[0].Right, funcs)
+		if err != nil {
+			return "", err
+		}
+		names := make([]string, 0, len(trees))
+		for name := range trees {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var sb strings.Builder
+		for _, name := range names {
+			sb.WriteString("{{define " + strconv.Quote(name) + "}}")
+			if root := trees[name].Root; root != nil {
+				writeTemplateNode(&sb, root)
+			}
+			sb.WriteString("{{end}}")
+		}
+		return sb.String(), nil
+	}
+
+	want, err := canonical(source)
+	if err != nil {
+		return fmt.Errorf("template validation: %w", err)
+	}
+	got, err := canonical(formatted)
+	if err != nil {
+		return fmt.Errorf("template validation: formatted template: %w", err)
+	}
+	if got != want {
+		return errors.New("template validation: the formatted template differs from the source")
+	}
+
+	return nil
+}
+
+// Whitespace next to tags, e.g. between <ul> and <li>, is added and
+// removed when formatting. Whitespace next to actions is not, it is
+// written when the template is executed.
+var (
+	spaceRe          = regexp.MustCompile(`\s+`)
+	tagSpaceReplacer = strings.NewReplacer("> ", ">", " <", "<")
+)
+
+// writeTemplateNode writes n to sb with whitespace in text collapsed and
+// whitespace next to tags left out.
+func writeTemplateNode(sb *strings.Builder, n parse.Node) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		for _, nn := range n.Nodes {
+			writeTemplateNode(sb, nn)
+		}
+	case *parse.TextNode:
+		text := spaceRe.ReplaceAllString(string(n.Text), " ")
+		sb.WriteString(tagSpaceReplacer.Replace(text))
+	case *parse.IfNode:
+		writeTemplateBranch(sb, "if", &n.BranchNode)
+	case *parse.RangeNode:
+		writeTemplateBranch(sb, "range", &n.BranchNode)
+	case *parse.WithNode:
+		writeTemplateBranch(sb, "with", &n.BranchNode)
+	default:
+		sb.WriteString(n.String())
+	}
+}
+
+func writeTemplateBranch(sb *strings.Builder, name string, n *parse.BranchNode) {
+	sb.WriteString("{{" + name + " " + n.Pipe.String() + "}}")
+	writeTemplateNode(sb, n.List)
+	if n.ElseList != nil {
+		sb.WriteString("{{else}}")
+		writeTemplateNode(sb, n.ElseList)
+	}
+	sb.WriteString("{{end}}")
+}
@@ -0,0 +1,148 @@
+package htmlfmt
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/net/html"
+)
+
+func TestReplaceActions(t *testing.T) {
+	c := qt.New(t)
+
+	s := &GoTemplateSyntax
+
+	for _, test := range []struct {
+		src     string
+		actions []string
+	}{
+		{"<p>{{ .Title }}</p>", []string{"{{ .Title }}"}},
+		{`<a href="{{ .URL }}" {{ if .X }}hidden{{ end }}>`, []string{"{{ .URL }}", "{{ if .X }}", "{{ end }}"}},
+		{`{{- printf "}}" -}}{{/* }} */}}{{ "\"}}" }}`, []string{`{{- printf "}}" -}}`, "{{/* }} */}}", `{{ "\"}}" }}`}},
+		{"{{ .A\n| b }}", []string{"{{ .A\n| b }}"}},
+		{"{{ .A", nil},
+		{"{{ .A }}{{ .B", []string{"{{ .A }}"}},
+		// The placeholder runes are replaced too.
+		{"a\uE000b{{ \"\uE001\" }}\uE001", []string{"\uE000", "{{ \"\uE001\" }}", "\uE001"}},
+	} {
+		b, actions, err := s.replaceActions([]byte(test.src))
+		c.Assert(err, qt.IsNil)
+		var got []string
+		for _, a := range actions {
+			got = append(got, string(a))
+		}
+		c.Assert(got, qt.DeepEquals, test.actions, qt.Commentf(test.src))
+		c.Assert(string(restoreActions(b, actions)), qt.Equals, test.src)
+	}
+
+	b, _, _ := s.replaceActions([]byte("{{ .Title }}"))
+	c.Assert(displayWidth(b), qt.Equals, len("{{ .Title }}"))
+
+	ss := goTemplateSyntax("[[", "]]")
+	s = &ss
+	b, actions, _ := s.replaceActions([]byte("[[ .A ]]{{ .B }}"))
+	c.Assert(len(actions), qt.Equals, 1)
+	c.Assert(string(restoreActions(b, actions)), qt.Equals, "[[ .A ]]{{ .B }}")
+}
+
+func TestTemplateTokenType(t *testing.T) {
+	c := qt.New(t)
+
+	s := &GoTemplateSyntax
+
+	for action, typ := range map[string]html.TokenType{
+		"{{ if .X }}":                    templateStartToken,
+		"{{- range $i, $e := .Pages -}}": templateStartToken,
+		"{{with .X}}":                    templateStartToken,
+		`{{ define "main" }}`:            templateStartToken,
+		`{{ block "main" . }}`:           templateStartToken,
+		"{{ else }}":                     templateElseToken,
+		"{{- else if .Y }}":              templateElseToken,
+		"{{ end }}":                      templateEndToken,
+		"{{end}}":                        templateEndToken,
+		"{{ .Title }}":                   html.TextToken,
+		`{{ template "x" . }}`:           html.TextToken,
+		"{{ iffy }}":                     html.TextToken,
+		"{{}}":                           html.TextToken,
+	} {
+		c.Assert(s.tokenType([]byte(action)), qt.Equals, typ, qt.Commentf(action))
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	c := qt.New(t)
+
+	s := &GoTemplateSyntax
+
+	validate := func(source, formatted string) error {
+		_, actions, err := s.replaceActions([]byte(source))
+		c.Assert(err, qt.IsNil)
+		return s.validate([]byte(source), []byte(formatted), actions)
+	}
+
+	c.Assert(validate("<ul>{{ range .X }}<li>{{ . }}</li>{{ end }}</ul>", "<ul>\n  {{ range .X }}\n    <li>{{ . }}</li>\n  {{ end }}\n</ul>"), qt.IsNil)
+	c.Assert(validate("<p>a  b {{- .X }}</p>", "<p>a b {{- .X }}</p>"), qt.IsNil)
+	c.Assert(validate(`{{ define "a" }}{{ myFunc .X }}{{ end }}`, `{{ define "a" }}{{ myFunc .X }}{{ end }}`), qt.IsNil)
+	c.Assert(validate("<p>{{ .X }}</p>", "<p>{{ .Y }}</p>"), qt.Not(qt.IsNil))
+	c.Assert(validate("<p>{{ if .X }}a{{ end }}b</p>", "<p>{{ if .X }}a b{{ end }}</p>"), qt.Not(qt.IsNil))
+	c.Assert(validate("<p>{{ .X }}</p>", "<p>{{ .X </p>"), qt.Not(qt.IsNil))
+}
+
+func TestTemplateSyntaxes(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		name    string
+		s       TemplateSyntax
+		src     string
+		actions []string
+		types   []html.TokenType
+	}{
+		{
+			"Jinja", JinjaSyntax,
+			`{# it's }} #}{% for u in users %}{{ u.name | e }}{%- else %}{% set x = "%}" %}{% endfor -%}`,
+			[]string{"{# it's }} #}", "{% for u in users %}", "{{ u.name | e }}", "{%- else %}", `{% set x = "%}" %}`, "{% endfor -%}"},
+			[]html.TokenType{html.TextToken, templateStartToken, html.TextToken, templateElseToken, html.TextToken, templateEndToken},
+		},
+		{
+			"Django", DjangoSyntax,
+			"{% for u in users %}{{ u }}{% empty %}{% endfor %}",
+			[]string{"{% for u in users %}", "{{ u }}", "{% empty %}", "{% endfor %}"},
+			[]html.TokenType{templateStartToken, html.TextToken, templateElseToken, templateEndToken},
+		},
+		{
+			"Handlebars", HandlebarsSyntax,
+			"{{!-- }} --}}{{#each items}}{{{html}}}{{else}}{{~/each}}",
+			[]string{"{{!-- }} --}}", "{{#each items}}", "{{{html}}}", "{{else}}", "{{~/each}}"},
+			[]html.TokenType{html.TextToken, templateStartToken, html.TextToken, templateElseToken, templateEndToken},
+		},
+		{
+			"Mustache", MustacheSyntax,
+			"{{! a }}{{#x}}{{y}}{{/x}}{{^x}}{{/x}}",
+			[]string{"{{! a }}", "{{#x}}", "{{y}}", "{{/x}}", "{{^x}}", "{{/x}}"},
+			[]html.TokenType{html.TextToken, templateStartToken, html.TextToken, templateEndToken, templateStartToken, templateEndToken},
+		},
+		{
+			"ERB", ERBSyntax,
+			`<%# %> %><% items.each do |i| %><%= i %><% if a %><% elsif b %><% end %><%= link_to "%>" %><% end -%>`,
+			[]string{"<%# %>", "<% items.each do |i| %>", "<%= i %>", "<% if a %>", "<% elsif b %>", "<% end %>", `<%= link_to "%>" %>`, "<% end -%>"},
+			[]html.TokenType{html.TextToken, templateStartToken, html.TextToken, templateStartToken, templateElseToken, templateEndToken, html.TextToken, templateEndToken},
+		},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			b, actions, err := test.s.replaceActions([]byte(test.src))
+			c.Assert(err, qt.IsNil)
+			var (
+				got   []string
+				types []html.TokenType
+			)
+			for _, a := range actions {
+				got = append(got, string(a))
+				types = append(types, test.s.tokenType(a))
+			}
+			c.Assert(got, qt.DeepEquals, test.actions)
+			c.Assert(types, qt.DeepEquals, test.types)
+			c.Assert(string(restoreActions(b, actions)), qt.Equals, test.src)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package htmlfmt
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// WhitespaceSensitivity configures how whitespace in the source is treated,
+// see WithWhitespaceSensitivity.
+type WhitespaceSensitivity int
+
+const (
+	// WhitespaceCSS treats whitespace as significant where the browser
+	// renders it, i.e. between and inside inline elements, but not next to
+	// block elements, where it collapses. This is the default.
+	WhitespaceCSS WhitespaceSensitivity = iota
+
+	// WhitespaceStrict treats all whitespace as significant, as if all
+	// elements were inline.
+	WhitespaceStrict
+
+	// WhitespaceIgnore treats all whitespace as insignificant, so
+	// whitespace between elements may be added or removed, e.g. the space
+	// in <span>a</span> <span>b</span>.
+	WhitespaceIgnore
+)
+
+// WithWhitespaceSensitivity configures the formatter's whitespace handling.
+//
+// With WhitespaceCSS and WhitespaceStrict, the formatted document renders
+// the same text as the source: lines are only broken where there was
+// whitespace already, or where it would be collapsed by the browser.
+func WithWhitespaceSensitivity(s WhitespaceSensitivity) Option {
+	return func(f *Formatter) { f.whitespace = s }
+}
+
+// canBreak reports whether a line break can be written between the
+// tokens before and after (either nil at the edges of the document)
+// without changing the rendered text.
+func (w *writer) canBreak(before, after *token) bool {
+	if w.f.whitespace == WhitespaceIgnore {
+		return true
+	}
+	return w.spaceOrBoundary(before, -1) || w.spaceOrBoundary(after, 1)
+}
+
+// canBreakAtAction is canBreak for a template action and the token before
+// or after it. Next to text, the line is only broken if it was in the
+// source, so e.g. <p>Say {{ if .Loud }}hi{{ end }}</p> stays on one line.
+func (w *writer) canBreakAtAction(before, after *token) bool {
+	if before == nil || after == nil {
+		return false
+	}
+	if before.typ == html.TextToken && !before.text.isWhitespaceOnly && !before.text.hadTrailingNewline {
+		return false
+	}
+	if after.typ == html.TextToken && !after.text.isWhitespaceOnly && !after.text.hadLeadingNewline {
+		return false
+	}
+	return w.canBreak(before, after)
+}
+
+// canWrap reports whether the content of the start tag t can be put on
+// separate lines, see canBreak.
+func (w *writer) canWrap(t *token) bool {
+	if !w.canBreak(t, w.iter.at(t.i+1)) {
+		return false
+	}
+	end := t.endElement
+	return end == nil || w.canBreak(w.iter.at(end.i-1), end)
+}
+
+// spaceOrBoundary walks from t in the direction dir (-1 or 1) past inline
+// tags and reports whether whitespace, or a point where whitespace is not
+// rendered (e.g. a block element), comes before any other content.
+func (w *writer) spaceOrBoundary(t *token, dir int) bool {
+	return w.walk(t, dir, true)
+}
+
+// boundary is like spaceOrBoundary, but walks past whitespace.
+func (w *writer) boundary(t *token, dir int) bool {
+	return w.walk(t, dir, false)
+}
+
+func (w *writer) walk(t *token, dir int, stopAtSpace bool) bool {
+	for ; t != nil; t = w.iter.at(t.i + dir) {
+		switch t.typ {
+		case html.TextToken:
+			if t.text.isWhitespaceOnly {
+				if stopAtSpace {
+					return true
+				}
+				continue
+			}
+			if !stopAtSpace {
+				return false
+			}
+			var r rune
+			if dir < 0 {
+				r, _ = utf8.DecodeLastRune(t.raw)
+			} else {
+				r, _ = utf8.DecodeRune(t.raw)
+			}
+			return unicode.IsSpace(r)
+		case html.CommentToken, templateStartToken, templateElseToken, templateEndToken:
+			// Not rendered.
+		case html.DoctypeToken:
+			return true
+		default:
+			if t.isVoid() && (t.isInline() || w.f.whitespace == WhitespaceStrict) {
+				// E.g. an image.
+				return false
+			}
+			if !t.isInline() && w.f.whitespace != WhitespaceStrict {
+				return true
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,80 @@
+package htmlfmt
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// The number of columns a tab character is assumed to occupy when
+// measuring indentation.
+const tabDisplayWidth = 4
+
+// Ranges of East Asian Wide and Fullwidth characters (and the emoji blocks
+// that terminals render as wide), sorted by start.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},   // Hangul Jamo
+	{0x231A, 0x231B},   // Watch, hourglass
+	{0x2329, 0x232A},   // Angle brackets
+	{0x2E80, 0x303E},   // CJK Radicals .. CJK Symbols and Punctuation
+	{0x3041, 0x33FF},   // Hiragana .. CJK Compatibility
+	{0x3400, 0x4DBF},   // CJK Unified Ideographs Extension A
+	{0x4E00, 0x9FFF},   // CJK Unified Ideographs
+	{0xA000, 0xA4CF},   // Yi
+	{0xA960, 0xA97F},   // Hangul Jamo Extended-A
+	{0xAC00, 0xD7A3},   // Hangul Syllables
+	{0xF900, 0xFAFF},   // CJK Compatibility Ideographs
+	{0xFE10, 0xFE19},   // Vertical forms
+	{0xFE30, 0xFE6F},   // CJK Compatibility Forms, Small Form Variants
+	{0xFF00, 0xFF60},   // Fullwidth Forms
+	{0xFFE0, 0xFFE6},   // Fullwidth signs
+	{0x1F300, 0x1F64F}, // Misc Symbols and Pictographs, Emoticons
+	{0x1F680, 0x1F6FF}, // Transport and Map Symbols
+	{0x1F900, 0x1F9FF}, // Supplemental Symbols and Pictographs
+	{0x20000, 0x2FFFD}, // CJK Extension B ..
+	{0x30000, 0x3FFFD}, // CJK Extension G ..
+}
+
+// runeWidth returns the number of display columns used by r.
+func runeWidth(r rune) int {
+	switch {
+	case r == '\t':
+		return tabDisplayWidth
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return 0
+	case r < 0x1100:
+		if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+			return 0
+		}
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	}
+
+	lo, hi := 0, len(wideRanges)
+	for lo < hi {
+		m := (lo + hi) / 2
+		rng := wideRanges[m]
+		switch {
+		case r < rng[0]:
+			hi = m
+		case r > rng[1]:
+			lo = m + 1
+		default:
+			return 2
+		}
+	}
+
+	return 1
+}
+
+// displayWidth returns the number of display columns used by the UTF-8
+// encoded b. Invalid UTF-8 counts as one column per byte.
+func displayWidth(b []byte) int {
+	w := 0
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		w += runeWidth(r)
+		b = b[size:]
+	}
+	return w
+}
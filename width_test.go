@@ -0,0 +1,22 @@
+package htmlfmt
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(displayWidth([]byte("")), qt.Equals, 0)
+	c.Assert(displayWidth([]byte("abc")), qt.Equals, 3)
+	c.Assert(displayWidth([]byte("\t")), qt.Equals, tabDisplayWidth)
+	c.Assert(displayWidth([]byte("æøå")), qt.Equals, 3)
+	c.Assert(displayWidth([]byte("日本語")), qt.Equals, 6)
+	c.Assert(displayWidth([]byte("한국어")), qt.Equals, 6)
+	c.Assert(displayWidth([]byte("ＡＢ")), qt.Equals, 4)
+	c.Assert(displayWidth([]byte("e\u0301")), qt.Equals, 1)
+	c.Assert(displayWidth([]byte("a\u200bb")), qt.Equals, 2)
+	c.Assert(displayWidth([]byte("\xff\xfe")), qt.Equals, 2)
+}